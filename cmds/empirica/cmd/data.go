@@ -0,0 +1,377 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/empiricaly/empirica/internal/data"
+	"github.com/empiricaly/empirica/internal/settings"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+func addDataCommands(parent *cobra.Command) error {
+	cmd := &cobra.Command{
+		Use:   "data",
+		Short: "Data file commands",
+		Long: `Commands to check and maintain the data file of an experiment
+(.empirica/local/tajriba.json).`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.NoArgs,
+	}
+
+	parent.AddCommand(cmd)
+
+	if err := addDataCheckCommand(cmd); err != nil {
+		return err
+	}
+
+	if err := addDataRepairCommand(cmd); err != nil {
+		return err
+	}
+
+	if err := addDataCompactCommand(cmd); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func addDataCheckCommand(parent *cobra.Command) error {
+	cmd := &cobra.Command{
+		Use:   "check [tajriba.json file]",
+		Short: "Check the data file for errors",
+		Long: `Check the data file for errors and print the problems found, with their line
+numbers.
+
+Errors are records that cannot be used, e.g. by export: invalid JSON (such as
+a last line truncated by a crash), attributes on unknown scopes, duplicate
+scopes, or vector attributes without a valid index. Warnings are records out
+of chronological order.
+
+If ran without arguments, at the root of an experiment, it will check the data
+file of the current experiment. Use "empirica data repair" to fix errors.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tajfile, err := dataFile(args)
+			if err != nil {
+				return err
+			}
+
+			file, err := os.Open(tajfile)
+			if err != nil {
+				return errors.Wrap(err, "open data file")
+			}
+			defer file.Close()
+
+			summary, err := data.Check(file, printProblem)
+			if err != nil {
+				return errors.Wrap(err, "check data file")
+			}
+
+			logSummary(summary).Msg("Data file checked")
+
+			if summary.Errors > 0 {
+				return errors.Errorf("found %d errors, repair with: empirica data repair", summary.Errors)
+			}
+
+			return nil
+		},
+	}
+
+	parent.AddCommand(cmd)
+
+	return nil
+}
+
+func addDataRepairCommand(parent *cobra.Command) error {
+	cmd := &cobra.Command{
+		Use:   "repair [tajriba.json file]",
+		Short: "Write a repaired copy of the data file",
+		Long: `Write a copy of the data file without the records with errors, see
+"empirica data check". The data file itself is not modified.
+
+The copy is written to the file given with the --out flag, or next to the data
+file, with a .repaired.json extension. The dropped records can be kept in a
+separate file with the --quarantine flag.
+
+Once the copy is checked, stop the server and replace the data file with it.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tajfile, err := dataFile(args)
+			if err != nil {
+				return err
+			}
+
+			out, err := cmd.Flags().GetString("out")
+			if err != nil {
+				return errors.Wrap(err, "parse out flag")
+			}
+
+			if out == "" {
+				out = strings.TrimSuffix(tajfile, filepath.Ext(tajfile)) + ".repaired.json"
+			}
+
+			quarantine, err := cmd.Flags().GetString("quarantine")
+			if err != nil {
+				return errors.Wrap(err, "parse quarantine flag")
+			}
+
+			for _, f := range []string{out, quarantine} {
+				if f != "" && sameFile(f, tajfile) {
+					return errors.New("cannot overwrite the data file")
+				}
+			}
+
+			file, err := os.Open(tajfile)
+			if err != nil {
+				return errors.Wrap(err, "open data file")
+			}
+			defer file.Close()
+
+			w, closeOut, err := createDataFile(out)
+			if err != nil {
+				return err
+			}
+
+			var q io.Writer
+
+			closeQuarantine := func() error { return nil }
+
+			if quarantine != "" {
+				q, closeQuarantine, err = createDataFile(quarantine)
+				if err != nil {
+					_ = closeOut()
+
+					return err
+				}
+			}
+
+			summary, err := data.Repair(file, w, q, printProblem)
+			if err != nil {
+				_ = closeOut()
+				_ = closeQuarantine()
+
+				return errors.Wrap(err, "repair data file")
+			}
+
+			if err := closeOut(); err != nil {
+				return err
+			}
+
+			if err := closeQuarantine(); err != nil {
+				return err
+			}
+
+			logSummary(summary).
+				Int("dropped", summary.Dropped).
+				Str("output", out).
+				Msg("Data file repaired")
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("out", "", "repaired data file name")
+	cmd.Flags().String("quarantine", "", "file name to write the dropped records to")
+
+	parent.AddCommand(cmd)
+
+	return nil
+}
+
+func addDataCompactCommand(parent *cobra.Command) error {
+	cmd := &cobra.Command{
+		Use:   "compact [tajriba.json file]",
+		Short: "Write a compacted copy of the data file",
+		Long: `Write a copy of the data file keeping only the last value of attributes that
+are not vectors. The final state of the data is unchanged, but the history of
+changes of attributes is dropped, so the file is smaller and faster to load
+on startup. The data file itself is not modified.
+
+The copy is written to the file given with the --out flag, or next to the data
+file, with a .compacted.json extension.
+
+With the --before flag, only the changes made before the given time are
+dropped (RFC 3339 format, 2006-01-02T15:04:05Z07:00, or date, 2006-01-02).
+With the --archive flag, the dropped changes are written to the given file.
+
+Once the copy is checked, stop the server and replace the data file with it.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tajfile, err := dataFile(args)
+			if err != nil {
+				return err
+			}
+
+			out, err := cmd.Flags().GetString("out")
+			if err != nil {
+				return errors.Wrap(err, "parse out flag")
+			}
+
+			if out == "" {
+				out = strings.TrimSuffix(tajfile, filepath.Ext(tajfile)) + ".compacted.json"
+			}
+
+			archive, err := cmd.Flags().GetString("archive")
+			if err != nil {
+				return errors.Wrap(err, "parse archive flag")
+			}
+
+			before, err := parseTimeFlag(cmd, "before")
+			if err != nil {
+				return err
+			}
+
+			for _, f := range []string{out, archive} {
+				if f != "" && sameFile(f, tajfile) {
+					return errors.New("cannot overwrite the data file")
+				}
+			}
+
+			file, err := os.Open(tajfile)
+			if err != nil {
+				return errors.Wrap(err, "open data file")
+			}
+			defer file.Close()
+
+			w, closeOut, err := createDataFile(out)
+			if err != nil {
+				return err
+			}
+
+			var a io.Writer
+
+			closeArchive := func() error { return nil }
+
+			if archive != "" {
+				a, closeArchive, err = createDataFile(archive)
+				if err != nil {
+					_ = closeOut()
+
+					return err
+				}
+			}
+
+			summary, err := data.Compact(file, w, a, before)
+			if err != nil {
+				_ = closeOut()
+				_ = closeArchive()
+
+				return errors.Wrap(err, "compact data file")
+			}
+
+			if err := closeOut(); err != nil {
+				return err
+			}
+
+			if err := closeArchive(); err != nil {
+				return err
+			}
+
+			log.Info().
+				Int("lines", summary.Lines).
+				Int("attributes", summary.Attributes).
+				Int("dropped", summary.Dropped).
+				Str("output", out).
+				Msg("Data file compacted")
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("out", "", "compacted data file name")
+	cmd.Flags().String("archive", "", "file name to write the dropped changes to")
+	cmd.Flags().String("before", "", "only drop changes made before this time")
+
+	parent.AddCommand(cmd)
+
+	return nil
+}
+
+// dataFile returns the data file given as argument, or the data file of the
+// current project.
+func dataFile(args []string) (string, error) {
+	if len(args) == 1 {
+		if _, err := os.Stat(args[0]); err != nil {
+			return "", errors.Wrap(err, "data file")
+		}
+
+		return args[0], nil
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", errors.Wrap(err, "get working directory")
+	}
+
+	tajfile := path.Join(wd, settings.EmpiricaDir, settings.LocalDir, "tajriba.json")
+
+	if _, err := os.Stat(tajfile); err != nil {
+		return "", errors.New("no tajriba.json file found, run within a project folder or give the file path")
+	}
+
+	return tajfile, nil
+}
+
+// createDataFile creates a buffered file. The returned function flushes and
+// closes the file.
+func createDataFile(filename string) (io.Writer, func() error, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create file")
+	}
+
+	w := bufio.NewWriter(file)
+
+	return w, func() error {
+		if err := w.Flush(); err != nil {
+			file.Close()
+
+			return errors.Wrap(err, "write file")
+		}
+
+		return errors.Wrap(file.Close(), "close file")
+	}, nil
+}
+
+func sameFile(a, b string) bool {
+	sa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+
+	sb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(sa, sb)
+}
+
+func printProblem(p *data.Problem) {
+	fmt.Println(p.String())
+}
+
+func logSummary(summary *data.Summary) *zerolog.Event {
+	return log.Info().
+		Int("lines", summary.Lines).
+		Int("scopes", summary.Scopes).
+		Int("attributes", summary.Attributes).
+		Int("errors", summary.Errors).
+		Int("warnings", summary.Warnings)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/empiricaly/empirica/internal/export"
@@ -15,9 +16,10 @@ import (
 
 func addExportCommand(parent *cobra.Command) error {
 	cmd := &cobra.Command{
-		Use:   "export [tajriba.json file]",
+		Use:   "export [tajriba.json files...]",
 		Short: "Export empirica data",
-		Long: `export empirica data to a zip file containing CSV files with the data.
+		Long: `Export empirica data to a zip file of CSV (default), JSON Lines or Parquet
+files, one per kind of scope, or to a SQLite database, with --format.
 
 If ran without arguments, at the root of an experiment, it will use the data
 file (.empirica/local/tajriba.json) of the current experiment.
@@ -28,13 +30,43 @@ locked in your project). Upgrade to the latest version of empirica with:
 
 	empirica upgrade --global
 
+Several files, e.g. from different servers of the same study, are exported as
+one dataset, with a source column naming the file of each row:
+
+	empirica export pilot=pilot/tajriba.json main=main/tajriba.json
+
+A running server is exported from its GraphQL endpoint with --url and --token,
+which registers an "export" service on the server, and a growing file is
+exported incrementally with --checkpoint.
+
 The output file can be specified with the --out flag. If not specified, it will
 be saved in the current working directory with the name:
 <experiment-name or empirica>-<timestamp>.zip.
+
+The zip file also contains a manifest.json file, with the checksums and options
+of the export, and a codebook/<kind>.json file per kind, describing its keys.
+
+The --anonymize flag takes a YAML file of rules, applied to attributes by key
+(with * and ? wildcards) and optionally kind. The salt can also be set with the
+EMPIRICA_EXPORT_SALT environment variable:
+
+	salt: a-long-random-secret
+	rules:
+	  - key: participantIdentifier
+	    action: hash # HMAC-SHA256 of the value, keyed with the salt
+	  - key: email
+	    action: drop
+	  - key: chat
+	    action: redact # all of the value without pattern
+	    pattern: '[\w.+-]+@[\w-]+\.[\w.]+'
+	    replacement: '[email]'
+	  - key: age
+	    action: bucket # size (20-30) or bounds (<18, 18-25, ...)
+	    bounds: [18, 25, 35, 50]
 `,
 		SilenceUsage:  true,
 		SilenceErrors: true,
-		Args:          cobra.MaximumNArgs(1),
+		Args:          cobra.ArbitraryArgs,
 		// Hidden:        true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			conf := getConfig(true)
@@ -49,15 +81,124 @@ be saved in the current working directory with the name:
 				return errors.Wrap(err, "parse out flag")
 			}
 
+			format, err := cmd.Flags().GetString("format")
+			if err != nil {
+				return errors.Wrap(err, "parse format flag")
+			}
+
+			vectors, err := cmd.Flags().GetString("vectors")
+			if err != nil {
+				return errors.Wrap(err, "parse vectors flag")
+			}
+
+			history, err := cmd.Flags().GetBool("history")
+			if err != nil {
+				return errors.Wrap(err, "parse history flag")
+			}
+
+			relational, err := cmd.Flags().GetBool("relational")
+			if err != nil {
+				return errors.Wrap(err, "parse relational flag")
+			}
+
+			since, err := parseTimeFlag(cmd, "since")
+			if err != nil {
+				return err
+			}
+
+			until, err := parseTimeFlag(cmd, "until")
+			if err != nil {
+				return err
+			}
+
+			kinds, err := cmd.Flags().GetStringSlice("kind")
+			if err != nil {
+				return errors.Wrap(err, "parse kind flag")
+			}
+
+			includeKeys, err := cmd.Flags().GetStringSlice("include-key")
+			if err != nil {
+				return errors.Wrap(err, "parse include-key flag")
+			}
+
+			excludeKeys, err := cmd.Flags().GetStringSlice("exclude-key")
+			if err != nil {
+				return errors.Wrap(err, "parse exclude-key flag")
+			}
+
+			games, err := cmd.Flags().GetStringSlice("game")
+			if err != nil {
+				return errors.Wrap(err, "parse game flag")
+			}
+
+			rulesFile, err := cmd.Flags().GetString("anonymize")
+			if err != nil {
+				return errors.Wrap(err, "parse anonymize flag")
+			}
+
+			var rules *export.Rules
+			if rulesFile != "" {
+				rules, err = export.LoadRules(rulesFile)
+				if err != nil {
+					return errors.Wrap(err, "load anonymization rules")
+				}
+			}
+
+			checkpoint, err := cmd.Flags().GetString("checkpoint")
+			if err != nil {
+				return errors.Wrap(err, "parse checkpoint flag")
+			}
+
 			wd, err := os.Getwd()
 			if err != nil {
 				return errors.Wrap(err, "get working directory")
 			}
 
-			var tajfile string
-			if len(args) == 1 {
-				tajfile = args[0]
-			} else {
+			url, err := cmd.Flags().GetString("url")
+			if err != nil {
+				return errors.Wrap(err, "parse url flag")
+			}
+
+			token, err := cmd.Flags().GetString("token")
+			if err != nil {
+				return errors.Wrap(err, "parse token flag")
+			}
+
+			if token == "" {
+				token = conf.Tajriba.Auth.ServiceRegistrationToken
+			}
+
+			var (
+				tajfile string
+				source  string
+				merge   []*export.Source
+			)
+
+			switch {
+			case url != "":
+				if len(args) > 0 {
+					return errors.New("cannot export from both a tajriba.json file and a server url")
+				}
+
+				if token == "" {
+					return errors.New("the --token flag is required to export from a server")
+				}
+
+				tajfile = url
+			case len(args) > 0:
+				sources := make([]*export.Source, len(args))
+				for i, arg := range args {
+					sources[i] = parseSource(arg)
+
+					if _, err := os.Stat(sources[i].File); err != nil {
+						return errors.Errorf("tajriba file not found: %s", sources[i].File)
+					}
+				}
+
+				tajfile = sources[0].File
+				source = sources[0].Name
+				merge = sources[1:]
+			default:
 				localDir := path.Join(wd, settings.EmpiricaDir, settings.LocalDir)
 
 				if _, err := os.Stat(localDir); err != nil {
@@ -67,8 +208,10 @@ be saved in the current working directory with the name:
 				tajfile = path.Join(localDir, "tajriba.json")
 			}
 
-			if _, err := os.Stat(tajfile); err != nil {
-				return errors.New("no tajriba.json file found, export must run within a project folder")
+			if url == "" {
+				if _, err := os.Stat(tajfile); err != nil {
+					return errors.New("no tajriba.json file found, export must run within a project folder")
+				}
 			}
 
 			experimentName := conf.Name
@@ -76,27 +219,116 @@ be saved in the current working directory with the name:
 				experimentName = "empirica"
 			}
 
+			f, ok := export.LookupFormat(format)
+			if !ok {
+				return errors.Errorf("unknown export format: %s (available: %s)",
+					format, strings.Join(export.Formats(), ", "))
+			}
+
+			var ext string
+			if f.Ext != "" {
+				ext = "." + f.Ext
+			}
+
 			filename := out
 			if filename == "" {
-				filename = path.Join(wd, fmt.Sprintf("%s-%s.zip", experimentName, time.Now().Format("2006-01-02-15-04-05")))
+				filename = path.Join(wd, fmt.Sprintf("%s-%s%s", experimentName, time.Now().Format("2006-01-02-15-04-05"), ext))
 			}
 
 			log.Info().
 				Str("output", filename).
 				Str("tajriba", tajfile).
-				Msg("Starting CSV export...")
+				Str("format", format).
+				Msg("Starting export...")
 
-			if err := export.ExportCSV(tajfile, filename); err != nil {
-				return errors.Wrap(err, "export csv")
+			opts := &export.Options{
+				Format:      format,
+				History:     history,
+				Vectors:     vectors,
+				Relational:  relational,
+				Since:       since,
+				Until:       until,
+				Kinds:       kinds,
+				IncludeKeys: includeKeys,
+				ExcludeKeys: excludeKeys,
+				Games:       games,
+				Anonymize:   rules,
+				Checkpoint:  checkpoint,
+				Merge:       merge,
+				Source:      source,
+				Project:     &export.Project{Name: conf.Name},
+			}
+
+			// The id file is missing when exporting a file outside of a
+			// project.
+			if id, err := settings.ReadIDFile(wd); err == nil {
+				opts.Project.ID = strings.TrimSpace(id)
+			}
+
+			if url != "" {
+				err = export.ExportRemote(ctx, url, token, filename, opts)
+			} else {
+				err = export.Export(tajfile, filename, opts)
+			}
+
+			if err != nil {
+				return errors.Wrap(err, "export")
 			}
 
 			return nil
 		},
 	}
 
-	cmd.Flags().String("out", "", "output zip file name")
+	cmd.Flags().String("out", "", "output file name")
+	cmd.Flags().String("format", export.FormatCSV, "format of exported files: "+strings.Join(export.Formats(), ", "))
+	cmd.Flags().String("vectors", export.VectorsJoined, "export of vectors in csv files: joined ([a,b,c]), long (vectors/<kind> file, one row per element) or wide (key_0 to key_n columns)")
+	cmd.Flags().Bool("relational", false, "export relations between scopes as foreign key columns (batchID, gameID, ...) and player join tables")
+	cmd.Flags().Bool("history", false, "also export every attribute change in history/<kind> files")
+	cmd.Flags().String("since", "", "only export data created from this time (RFC 3339 or date)")
+	cmd.Flags().String("until", "", "only export data created before this time (RFC 3339 or date)")
+	cmd.Flags().StringSlice("kind", nil, "only export these kinds of scopes")
+	cmd.Flags().StringSlice("include-key", nil, "only export attributes with keys matching these patterns (* and ? wildcards)")
+	cmd.Flags().StringSlice("exclude-key", nil, "do not export attributes with keys matching these patterns (* and ? wildcards)")
+	cmd.Flags().StringSlice("game", nil, "only export these games, with their rounds, stages and players")
+	cmd.Flags().String("anonymize", "", "anonymize attribute values with the rules of this YAML file")
+	cmd.Flags().String("checkpoint", "", "directory keeping the data read between exports, to only read new data (not with --anonymize, the data is kept as is)")
+	cmd.Flags().String("url", "", "export from the GraphQL endpoint of a running server instead of a file, as an \"export\" service")
+	cmd.Flags().String("token", "", "service registration token of the server (default from the project config)")
 
 	parent.AddCommand(cmd)
 
 	return nil
 }
+
+// parseSource parses a tajriba file argument, as path or name=path.
+func parseSource(arg string) *export.Source {
+	if _, err := os.Stat(arg); err == nil {
+		return &export.Source{File: arg}
+	}
+
+	if i := strings.Index(arg, "="); i > 0 {
+		return &export.Source{Name: arg[:i], File: arg[i+1:]}
+	}
+
+	return &export.Source{File: arg}
+}
+
+// parseTimeFlag parses a time flag, in RFC 3339 format or as a date.
+func parseTimeFlag(cmd *cobra.Command, name string) (time.Time, error) {
+	val, err := cmd.Flags().GetString(name)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "parse %s flag", name)
+	}
+
+	if val == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, val); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.Errorf("invalid %s time: %s", name, val)
+}
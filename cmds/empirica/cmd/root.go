@@ -134,6 +134,7 @@ func Execute() {
 	failedStart(addVersionCommand(rootCmd))
 	failedStart(addTajribaCommand(rootCmd))
 	failedStart(addExportCommand(rootCmd))
+	failedStart(addDataCommands(rootCmd))
 	failedStart(addPathsCommand(rootCmd))
 
 	failedStart(addUtilsCommands(rootCmd))
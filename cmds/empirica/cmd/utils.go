@@ -1,16 +1,29 @@
 package cmd
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path"
+	"strings"
 
+	"github.com/empiricaly/empirica/internal/server"
+	"github.com/empiricaly/empirica/internal/settings"
 	"github.com/empiricaly/empirica/internal/treatments"
+	"github.com/go-playground/validator/v10"
 	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v2"
 )
 
+// cliAuthor is the author of the versions of configuration files written by
+// commands, see server.WriteVersion.
+const cliAuthor = "cli"
+
 func addUtilsCommands(parent *cobra.Command) error {
 	cmd := &cobra.Command{
 		Use:   "utils",
@@ -55,6 +68,22 @@ func addUtilsTreatmentsCommands(parent *cobra.Command) error {
 		return err
 	}
 
+	if err := addUtilsTreatmentsGenerateCommand(cmd); err != nil {
+		return err
+	}
+
+	if err := addUtilsTreatmentsLintCommand(cmd); err != nil {
+		return err
+	}
+
+	if err := addUtilsTreatmentsExportCommand(cmd); err != nil {
+		return err
+	}
+
+	if err := addUtilsTreatmentsImportCommand(cmd); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -144,8 +173,22 @@ func addUtilsTreatmentsConvertCommand(parent *cobra.Command) error {
 
 			for _, t := range v1.FactorTypes {
 				fact := &treatments.Factor{
-					Name: t.Name,
-					Desc: t.Description,
+					Name:     t.Name,
+					Desc:     t.Description,
+					Type:     strings.ToLower(t.Type),
+					Required: t.Required,
+				}
+
+				if fact.Type == treatments.TypeInteger || fact.Type == treatments.TypeNumber {
+					if t.Min != 0 {
+						min := float64(t.Min)
+						fact.Min = &min
+					}
+
+					if t.Max != 0 {
+						max := float64(t.Max)
+						fact.Max = &max
+					}
 				}
 				for _, f := range v1.Factors {
 					if f.FactorTypeId == t.ID {
@@ -203,3 +246,431 @@ func addUtilsTreatmentsConvertCommand(parent *cobra.Command) error {
 
 	return nil
 }
+
+func addUtilsTreatmentsGenerateCommand(parent *cobra.Command) error {
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate treatments from the values of the factors",
+		Long: `Generate treatments from the values of the factors declared in treatments.yaml
+and write them to the file.
+
+Designs (--design):
+  full        every combination of the values of the factors
+  fractional  the combinations where the sum of the indexes of the values is
+              a multiple of n, for factors with n values each: n^(k-1) of the
+              n^k combinations of k factors (half fraction for 2 values)
+  latin       Latin square of 3 factors with n values each (n² treatments)
+
+The factors crossed are selected with --factors, all factors by default.
+Treatments are named with the --name template, executed with the values of the
+factors, e.g. --name "{{.playerCount}} players". Combinations are excluded with
+--exclude, e.g. --exclude playerCount=1,color=red.
+
+Generated treatments with the same factors as an existing treatment are
+skipped, unless --replace is set. Use --dry-run to print the generated
+treatments without writing them.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := cmd.Flags().GetString("file")
+			if err != nil {
+				return errors.Wrap(err, "parse file flag")
+			}
+
+			design := &treatments.Design{}
+
+			design.Kind, err = cmd.Flags().GetString("design")
+			if err != nil {
+				return errors.Wrap(err, "parse design flag")
+			}
+
+			design.Factors, err = cmd.Flags().GetStringSlice("factors")
+			if err != nil {
+				return errors.Wrap(err, "parse factors flag")
+			}
+
+			design.Name, err = cmd.Flags().GetString("name")
+			if err != nil {
+				return errors.Wrap(err, "parse name flag")
+			}
+
+			excludes, err := cmd.Flags().GetStringArray("exclude")
+			if err != nil {
+				return errors.Wrap(err, "parse exclude flag")
+			}
+
+			for _, e := range excludes {
+				c, err := parseConstraint(e)
+				if err != nil {
+					return err
+				}
+
+				design.Exclude = append(design.Exclude, c)
+			}
+
+			replace, err := cmd.Flags().GetBool("replace")
+			if err != nil {
+				return errors.Wrap(err, "parse replace flag")
+			}
+
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return errors.Wrap(err, "parse dry-run flag")
+			}
+
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return errors.Wrap(err, "read treatments file")
+			}
+
+			t := &treatments.Treatments{}
+			if err := yaml.Unmarshal(content, t); err != nil {
+				return errors.Wrap(err, "parse treatments file")
+			}
+
+			generated, err := t.Generate(design)
+			if err != nil {
+				return errors.Wrap(err, "generate treatments")
+			}
+
+			if dryRun {
+				b, err := yaml.Marshal(struct {
+					Treatments []*treatments.Treatment `yaml:"treatments"`
+				}{
+					Treatments: generated,
+				})
+				if err != nil {
+					return errors.Wrap(err, "encode treatments")
+				}
+
+				_, err = cmd.OutOrStdout().Write(b)
+
+				return errors.Wrap(err, "write treatments")
+			}
+
+			added := t.Add(generated, replace)
+
+			if err := validator.New().Struct(t); err != nil {
+				return errors.Wrap(err, "validate treatments")
+			}
+
+			if err := t.Check(); err != nil {
+				return errors.Wrap(err, "check treatments")
+			}
+
+			b, err := yaml.Marshal(t)
+			if err != nil {
+				return errors.Wrap(err, "encode treatments")
+			}
+
+			if _, err := server.WriteVersion(file, b, cliAuthor); err != nil {
+				return errors.Wrap(err, "write treatments file")
+			}
+
+			log.Info().
+				Int("generated", len(generated)).
+				Int("added", added).
+				Str("file", file).
+				Msg("Treatments generated")
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("file", path.Join(settings.EmpiricaDir, settings.TreatmentsYAML), "treatments file")
+	cmd.Flags().StringP("design", "d", treatments.DesignFull, "design: full, fractional or latin")
+	cmd.Flags().StringSlice("factors", nil, "factors to cross (default all)")
+	cmd.Flags().String("name", "", "template of the names of the treatments")
+	cmd.Flags().StringArray("exclude", nil, "combination of values to exclude, as factor=value,... (repeatable)")
+	cmd.Flags().Bool("replace", false, "replace the existing treatments")
+	cmd.Flags().Bool("dry-run", false, "print the generated treatments without writing them")
+
+	parent.AddCommand(cmd)
+
+	return nil
+}
+
+func addUtilsTreatmentsLintCommand(parent *cobra.Command) error {
+	cmd := &cobra.Command{
+		Use:   "lint [treatments.yaml file]",
+		Short: "Check the treatments file for errors",
+		Long: `Check the treatments file for errors and print the issues found, with their
+line numbers.
+
+Besides invalid fields, issues are duplicate factors, factor values or
+treatment names, treatments with factors or factor values that are not
+declared, and treatments without a valid playerCount.
+
+If ran without arguments, at the root of an experiment, it will check the
+treatments file of the current experiment.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file := path.Join(settings.EmpiricaDir, settings.TreatmentsYAML)
+			if len(args) > 0 {
+				file = args[0]
+			}
+
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return errors.Wrap(err, "read treatments file")
+			}
+
+			issues, err := treatments.Lint(content)
+			if err != nil {
+				return errors.Wrap(err, "lint treatments file")
+			}
+
+			for _, issue := range issues {
+				fmt.Printf("%s:%s\n", file, issue)
+			}
+
+			if len(issues) > 0 {
+				return errors.Errorf("found %d issues in %s", len(issues), file)
+			}
+
+			log.Info().Str("file", file).Msg("Treatments file checked")
+
+			return nil
+		},
+	}
+
+	parent.AddCommand(cmd)
+
+	return nil
+}
+
+func addUtilsTreatmentsExportCommand(parent *cobra.Command) error {
+	cmd := &cobra.Command{
+		Use:   "export [treatments.csv file]",
+		Short: "Export the treatments to CSV",
+		Long: `Export the treatments of treatments.yaml to CSV, to edit them in a spreadsheet.
+
+The CSV has one row per treatment and the columns name, desc, one column per
+factor, then weight, targetCount and maxGames if any treatment sets them.
+Factors not set on a treatment are empty. Values that are objects or arrays are
+written as JSON.
+
+If ran without arguments, or with -, the CSV is printed.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := cmd.Flags().GetString("file")
+			if err != nil {
+				return errors.Wrap(err, "parse file flag")
+			}
+
+			comma, err := commaFlag(cmd)
+			if err != nil {
+				return err
+			}
+
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return errors.Wrap(err, "read treatments file")
+			}
+
+			t := &treatments.Treatments{}
+			if err := yaml.Unmarshal(content, t); err != nil {
+				return errors.Wrap(err, "parse treatments file")
+			}
+
+			var buf bytes.Buffer
+			if err := t.WriteCSV(&buf, comma); err != nil {
+				return errors.Wrap(err, "encode csv")
+			}
+
+			if len(args) == 0 || args[0] == "-" {
+				_, err = buf.WriteTo(cmd.OutOrStdout())
+
+				return errors.Wrap(err, "write csv")
+			}
+
+			if err := server.WriteFileAtomic(args[0], buf.Bytes()); err != nil {
+				return errors.Wrap(err, "write csv file")
+			}
+
+			log.Info().
+				Int("treatments", len(t.Treatments)).
+				Str("file", args[0]).
+				Msg("Treatments exported")
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("file", path.Join(settings.EmpiricaDir, settings.TreatmentsYAML), "treatments file")
+	cmd.Flags().String("comma", ",", "field delimiter, e.g. ; or tab")
+
+	parent.AddCommand(cmd)
+
+	return nil
+}
+
+func addUtilsTreatmentsImportCommand(parent *cobra.Command) error {
+	cmd := &cobra.Command{
+		Use:   "import <treatments.csv file>",
+		Short: "Import the treatments from CSV",
+		Long: `Import the treatments from CSV, in the layout of export, and replace the
+treatments of treatments.yaml with them.
+
+The name column is required, other columns are optional. Columns that are not
+declared factors add a factor, and values that are not declared are added to
+the values of factors that have values. Cells are parsed with the type of their
+factor, empty cells leave the factor unset, its default applies.
+
+The treatments are checked as with lint, and nothing is written if there are
+issues. Issues are located in treatments.yaml as it would be written. Use
+--dry-run to print the changes to treatments.yaml without writing them.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Args:          cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := cmd.Flags().GetString("file")
+			if err != nil {
+				return errors.Wrap(err, "parse file flag")
+			}
+
+			comma, err := commaFlag(cmd)
+			if err != nil {
+				return err
+			}
+
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return errors.Wrap(err, "parse dry-run flag")
+			}
+
+			t := &treatments.Treatments{}
+
+			content, err := ioutil.ReadFile(file)
+			if err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "read treatments file")
+			}
+
+			if err := yaml.Unmarshal(content, t); err != nil {
+				return errors.Wrap(err, "parse treatments file")
+			}
+
+			before, err := yaml.Marshal(t)
+			if err != nil {
+				return errors.Wrap(err, "encode treatments")
+			}
+
+			f, err := os.Open(args[0])
+			if err != nil {
+				return errors.Wrap(err, "open csv file")
+			}
+
+			err = t.ReadCSV(f, comma)
+			f.Close()
+
+			if err != nil {
+				return errors.Wrapf(err, "import %s", args[0])
+			}
+
+			after, err := yaml.Marshal(t)
+			if err != nil {
+				return errors.Wrap(err, "encode treatments")
+			}
+
+			issues, err := treatments.Lint(after)
+			if err != nil {
+				return errors.Wrap(err, "lint treatments")
+			}
+
+			// Issues are located in the treatments file as it would be
+			// written, as with lint.
+			for _, issue := range issues {
+				fmt.Printf("%s:%s\n", file, issue)
+			}
+
+			if len(issues) > 0 {
+				return errors.Errorf("found %d issues in %s", len(issues), args[0])
+			}
+
+			if dryRun {
+				if bytes.Equal(before, after) {
+					log.Info().Str("file", file).Msg("No changes")
+
+					return nil
+				}
+
+				diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+					A:        difflib.SplitLines(string(before)),
+					B:        difflib.SplitLines(string(after)),
+					FromFile: file,
+					ToFile:   file,
+					Context:  3,
+				})
+				if err != nil {
+					return errors.Wrap(err, "diff treatments")
+				}
+
+				_, err = fmt.Fprint(cmd.OutOrStdout(), diff)
+
+				return errors.Wrap(err, "write diff")
+			}
+
+			if _, err := server.WriteVersion(file, after, cliAuthor); err != nil {
+				return errors.Wrap(err, "write treatments file")
+			}
+
+			log.Info().
+				Int("treatments", len(t.Treatments)).
+				Str("file", file).
+				Msg("Treatments imported")
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("file", path.Join(settings.EmpiricaDir, settings.TreatmentsYAML), "treatments file")
+	cmd.Flags().String("comma", ",", "field delimiter, e.g. ; or tab")
+	cmd.Flags().Bool("dry-run", false, "print the changes to the treatments file without writing them")
+
+	parent.AddCommand(cmd)
+
+	return nil
+}
+
+// commaFlag returns the CSV field delimiter of the comma flag: a single
+// character, or "tab".
+func commaFlag(cmd *cobra.Command) (rune, error) {
+	s, err := cmd.Flags().GetString("comma")
+	if err != nil {
+		return 0, errors.Wrap(err, "parse comma flag")
+	}
+
+	switch s {
+	case "tab", "\\t":
+		return '\t', nil
+	}
+
+	r := []rune(s)
+	if len(r) != 1 {
+		return 0, errors.Errorf("invalid comma: %q, expected a single character", s)
+	}
+
+	return r[0], nil
+}
+
+// parseConstraint parses an exclusion constraint: factor=value pairs,
+// separated by commas.
+func parseConstraint(s string) (treatments.Constraint, error) {
+	c := make(treatments.Constraint)
+
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errors.Errorf("invalid exclude: %q, expected factor=value,...", s)
+		}
+
+		c[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return c, nil
+}
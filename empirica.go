@@ -64,6 +64,7 @@ func Start(ctx context.Context, config *Config, usingConfigFile bool) (*Runner,
 
 	// Pass down if production
 	config.Server.Production = config.Production
+	config.Server.Token = config.Tajriba.Auth.ServiceRegistrationToken
 
 	if r.server, err = server.Prepare(config.Server); err != nil {
 		return nil, errors.Wrap(err, "prepare server")
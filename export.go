@@ -0,0 +1,73 @@
+package empirica
+
+import (
+	"github.com/empiricaly/empirica/internal/export"
+)
+
+// Export types, to add export formats to Empirica, see RegisterExportFormat.
+type (
+	// ExportOptions configures what is exported.
+	ExportOptions = export.Options
+
+	// ExportFormat is an export format, with the Writer of its exports.
+	ExportFormat = export.Format
+
+	// ExportWriter writes the data of an export: every kind of Scope, with
+	// its Scopes and attribute changes.
+	ExportWriter = export.Writer
+
+	// ExportZipFunc writes the files of a kind in a zip file, see
+	// NewExportZipWriter.
+	ExportZipFunc = export.ZipFunc
+
+	// ExportZip is a zip file being written by an ExportZipFunc.
+	ExportZip = export.Zip
+
+	// ExportZipFile is a file of an ExportZip.
+	ExportZipFile = export.ZipFile
+
+	// ExportManifestWriter is implemented by ExportWriters recording the
+	// manifest of their exports.
+	ExportManifestWriter = export.ManifestWriter
+
+	// ExportManifest describes an export and the files exported.
+	ExportManifest = export.Manifest
+
+	// ExportFileInfo describes an exported file, in an ExportManifest.
+	ExportFileInfo = export.FileInfo
+
+	// ExportProject identifies the project of the exported data.
+	ExportProject = export.Project
+
+	// ExportKind is a kind of Scope, with its Scopes and attribute changes.
+	ExportKind = export.Kind
+
+	// ExportScope is a Scope with the last value of its attributes.
+	ExportScope = export.Scope
+
+	// ExportAttribute is the last state of an attribute on a Scope.
+	ExportAttribute = export.Attribute
+
+	// ExportChange is a single write of an attribute on a Scope.
+	ExportChange = export.Change
+)
+
+// RegisterExportFormat adds an export format, selected with the Format
+// option or the --format flag of the export command. It panics if a format
+// with the same name is already registered, and should be called from an init
+// function, before running the empirica command.
+func RegisterExportFormat(format *ExportFormat) {
+	export.RegisterFormat(format)
+}
+
+// NewExportZipWriter returns an ExportWriter creating a zip file, with the
+// files written by write, the codebook of each kind and the manifest.
+func NewExportZipWriter(filename string, write ExportZipFunc) (ExportWriter, error) {
+	return export.NewZipWriter(filename, write)
+}
+
+// Export exports the data of the tajriba file to filename, in the format of
+// the options.
+func Export(tajfile, filename string, opts *ExportOptions) error {
+	return export.Export(tajfile, filename, opts)
+}
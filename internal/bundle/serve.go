@@ -161,10 +161,7 @@ func Serve(ctx context.Context, config *empirica.Config, in string, clean, devMo
 	s.Router.NotFound = playerFS
 
 	s.Router.GET("/dev", server.DevCheck(conf.Production))
-	s.Router.GET("/treatments", server.ReadTreatments(conf.Server.Treatments))
-	s.Router.PUT("/treatments", server.WriteTreatments(conf.Server.Treatments))
-	s.Router.GET("/lobbies", server.ReadLobbies(conf.Server.Lobbies))
-	s.Router.PUT("/lobbies", server.WriteLobbies(conf.Server.Lobbies))
+	server.EnableConfigFiles(conf.Server, s.Router)
 	s.Router.ServeFiles("/admin/*filepath", templates.HTTPFS("admin-ui"))
 
 	ctx, taj, schema, err := tajriba.Setup(ctx, conf.Tajriba, false)
@@ -79,6 +79,7 @@ func prepDotEmpirica(inConf *empirica.Config, dir string, devMode bool) (*empiri
 	// FIXME configuration manual tweaking is not ideal
 
 	conf.Callbacks.Token = conf.Tajriba.Auth.ServiceRegistrationToken
+	conf.Server.Token = conf.Tajriba.Auth.ServiceRegistrationToken
 	conf.Production = !devMode
 	conf.Server.Production = !devMode
 	conf.Tajriba.Server.Production = !devMode
@@ -0,0 +1,252 @@
+// Package data checks and maintains tajriba files, the log of all the data of
+// an experiment.
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxVectorIndex is the largest valid index of a vector attribute element.
+// Larger indices are most likely corrupted and would allocate huge vectors
+// on export.
+const maxVectorIndex = 1 << 20
+
+// Problem is an issue with a line of a tajriba file.
+type Problem struct {
+	// Line is the line number, starting at 1.
+	Line    int
+	Message string
+
+	// Fatal problems prevent using the record, e.g. to export data. Records
+	// with fatal problems are dropped on repair, other problems are only
+	// reported.
+	Fatal bool
+}
+
+func (p *Problem) String() string {
+	level := "warning"
+	if p.Fatal {
+		level = "error"
+	}
+
+	return fmt.Sprintf("line %d: %s: %s", p.Line, level, p.Message)
+}
+
+// Summary counts the records and problems of a tajriba file.
+type Summary struct {
+	Lines      int
+	Records    int
+	Scopes     int
+	Attributes int
+	Errors     int
+	Warnings   int
+
+	// Dropped is the number of lines dropped on repair or compaction.
+	Dropped int
+}
+
+type record struct {
+	Kind string          `json:"kind"`
+	Obj  json.RawMessage `json:"obj"`
+}
+
+type object struct {
+	ID        *string     `json:"id"`
+	CreatedAt *string     `json:"createdAt"`
+	Key       interface{} `json:"key"`
+	Vector    bool        `json:"vector"`
+	Index     *float64    `json:"index"`
+	NodeID    *string     `json:"nodeID"`
+}
+
+// checker validates the records of a tajriba file, in order.
+type checker struct {
+	scopes  map[string]bool
+	last    time.Time
+	summary *Summary
+}
+
+func newChecker() *checker {
+	return &checker{
+		scopes:  make(map[string]bool),
+		summary: &Summary{},
+	}
+}
+
+// check returns the problems of a line.
+func (c *checker) check(line []byte) []*Problem {
+	c.summary.Lines++
+
+	var problems []*Problem
+
+	problem := func(fatal bool, format string, args ...interface{}) {
+		problems = append(problems, &Problem{
+			Line:    c.summary.Lines,
+			Message: fmt.Sprintf(format, args...),
+			Fatal:   fatal,
+		})
+
+		if fatal {
+			c.summary.Errors++
+		} else {
+			c.summary.Warnings++
+		}
+	}
+
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+
+	var rec record
+	if err := json.Unmarshal(line, &rec); err != nil {
+		problem(true, "invalid JSON: %s", err)
+
+		return problems
+	}
+
+	if rec.Kind == "" {
+		problem(true, "record without kind")
+
+		return problems
+	}
+
+	var obj object
+	if err := json.Unmarshal(rec.Obj, &obj); err != nil {
+		problem(true, "invalid %s record: %s", rec.Kind, err)
+
+		return problems
+	}
+
+	c.summary.Records++
+
+	if obj.CreatedAt != nil {
+		t, err := time.Parse(time.RFC3339Nano, *obj.CreatedAt)
+
+		switch {
+		case err != nil:
+			problem(true, "invalid createdAt: %s", *obj.CreatedAt)
+		case t.Before(c.last):
+			problem(false, "createdAt %s is before the previous record (%s)",
+				*obj.CreatedAt, c.last.Format(time.RFC3339Nano))
+		default:
+			c.last = t
+		}
+	}
+
+	switch rec.Kind {
+	case "Scope":
+		c.summary.Scopes++
+
+		switch {
+		case obj.ID == nil || *obj.ID == "":
+			problem(true, "scope without id")
+		case c.scopes[*obj.ID]:
+			problem(true, "duplicate scope %s", *obj.ID)
+		}
+	case "Attribute":
+		c.summary.Attributes++
+
+		if _, ok := obj.Key.(string); !ok {
+			problem(true, "attribute without key")
+		}
+
+		if obj.CreatedAt == nil {
+			problem(true, "attribute without createdAt")
+		}
+
+		switch {
+		case obj.NodeID == nil || *obj.NodeID == "":
+			problem(true, "attribute without nodeID")
+		case !c.scopes[*obj.NodeID]:
+			problem(true, "scope %s not found", *obj.NodeID)
+		}
+
+		if obj.Vector {
+			switch {
+			case obj.Index == nil:
+				problem(true, "vector without index")
+			case *obj.Index < 0 || *obj.Index != math.Trunc(*obj.Index) || *obj.Index > maxVectorIndex:
+				problem(true, "invalid vector index %v", *obj.Index)
+			}
+		}
+	}
+
+	// Only keep track of valid scopes, attributes of invalid scopes are
+	// invalid too.
+	if rec.Kind == "Scope" && obj.ID != nil && !hasFatal(problems) {
+		c.scopes[*obj.ID] = true
+	}
+
+	return problems
+}
+
+func hasFatal(problems []*Problem) bool {
+	for _, p := range problems {
+		if p.Fatal {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check validates the tajriba file read from r: every line must be a JSON
+// record, attributes must be on known scopes, vector attributes must have a
+// valid index, and records should be in chronological order. Records of other
+// kinds than scopes and attributes are only checked for their createdAt. fn is
+// called with every problem found.
+func Check(r io.Reader, fn func(*Problem)) (*Summary, error) {
+	return Repair(r, nil, nil, fn)
+}
+
+// Repair checks the tajriba file read from r like Check, and writes to w the
+// lines without fatal problems. Dropped lines are written to quarantine, if
+// not nil.
+func Repair(r io.Reader, w, quarantine io.Writer, fn func(*Problem)) (*Summary, error) {
+	c := newChecker()
+	br := bufio.NewReaderSize(r, 256*1024)
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			problems := c.check(line)
+
+			for _, p := range problems {
+				fn(p)
+			}
+
+			out := w
+			if hasFatal(problems) {
+				c.summary.Dropped++
+				out = quarantine
+			}
+
+			if out != nil {
+				if !bytes.HasSuffix(line, []byte("\n")) {
+					line = append(line, '\n')
+				}
+
+				if _, werr := out.Write(line); werr != nil {
+					return c.summary, errors.Wrap(werr, "write line")
+				}
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return c.summary, nil
+		}
+
+		if err != nil {
+			return c.summary, errors.Wrap(err, "read line")
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func scopeLine(id string, sec int) string {
+	return fmt.Sprintf(`{"kind":"Scope","obj":{"id":%q,"kind":"game","createdAt":"2023-01-01T00:00:%02dZ"}}`, id, sec)
+}
+
+func attributeLine(id, nodeID, key, val string, sec int) string {
+	return fmt.Sprintf(`{"kind":"Attribute","obj":{"id":%q,"key":%q,"val":%q,"nodeID":%q,"createdAt":"2023-01-01T00:00:%02dZ"}}`,
+		id, key, val, nodeID, sec)
+}
+
+func vectorLine(id, nodeID, key, val, index string, sec int) string {
+	return fmt.Sprintf(`{"kind":"Attribute","obj":{"id":%q,"key":%q,"val":%q,"nodeID":%q,"vector":true%s,"createdAt":"2023-01-01T00:00:%02dZ"}}`,
+		id, key, val, nodeID, index, sec)
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		problems []string
+		dropped  int
+	}{
+		{
+			name: "valid",
+			content: strings.Join([]string{
+				scopeLine("s1", 0),
+				attributeLine("a1", "s1", "status", `"running"`, 1),
+				vectorLine("a2", "s1", "chat", `"hi"`, `,"index":0`, 2),
+			}, "\n") + "\n",
+		},
+		{
+			name: "truncated last line",
+			content: scopeLine("s1", 0) + "\n" +
+				attributeLine("a1", "s1", "status", `"running"`, 1)[:40],
+			problems: []string{"line 2: error: invalid JSON"},
+			dropped:  1,
+		},
+		{
+			name: "invalid JSON",
+			content: strings.Join([]string{
+				scopeLine("s1", 0),
+				`{"kind":"Attribute",`,
+				attributeLine("a1", "s1", "status", `"running"`, 1),
+			}, "\n") + "\n",
+			problems: []string{"line 2: error: invalid JSON"},
+			dropped:  1,
+		},
+		{
+			name: "invalid record",
+			content: strings.Join([]string{
+				`{"obj":{"id":"s1"}}`,
+				`{"kind":"Scope","obj":1}`,
+			}, "\n") + "\n",
+			problems: []string{
+				"line 1: error: record without kind",
+				"line 2: error: invalid Scope record",
+			},
+			dropped: 2,
+		},
+		{
+			name: "other kinds kept",
+			content: strings.Join([]string{
+				`{"kind":"Participant","obj":{"id":"p1","createdAt":"2023-01-01T00:00:00Z"}}`,
+				scopeLine("s1", 1),
+			}, "\n") + "\n",
+		},
+		{
+			name: "dangling nodeID",
+			content: strings.Join([]string{
+				scopeLine("s1", 0),
+				attributeLine("a1", "s2", "status", `"running"`, 1),
+				attributeLine("a2", "", "status", `"running"`, 2),
+				scopeLine("s2", 3),
+			}, "\n") + "\n",
+			problems: []string{
+				"line 2: error: scope s2 not found",
+				"line 3: error: attribute without nodeID",
+			},
+			dropped: 2,
+		},
+		{
+			name: "attributes of duplicate scopes",
+			content: strings.Join([]string{
+				scopeLine("s1", 0),
+				scopeLine("s1", 1),
+				attributeLine("a1", "s1", "status", `"running"`, 2),
+			}, "\n") + "\n",
+			problems: []string{"line 2: error: duplicate scope s1"},
+			dropped:  1,
+		},
+		{
+			name: "vector indices",
+			content: strings.Join([]string{
+				scopeLine("s1", 0),
+				vectorLine("a1", "s1", "chat", `"hi"`, "", 1),
+				vectorLine("a2", "s1", "chat", `"hi"`, `,"index":-1`, 2),
+				vectorLine("a3", "s1", "chat", `"hi"`, `,"index":1.5`, 3),
+				vectorLine("a4", "s1", "chat", `"hi"`, `,"index":1e9`, 4),
+			}, "\n") + "\n",
+			problems: []string{
+				"line 2: error: vector without index",
+				"line 3: error: invalid vector index -1",
+				"line 4: error: invalid vector index 1.5",
+				"line 5: error: invalid vector index 1e+09",
+			},
+			dropped: 4,
+		},
+		{
+			name: "records out of order",
+			content: strings.Join([]string{
+				scopeLine("s1", 5),
+				attributeLine("a1", "s1", "status", `"running"`, 1),
+				attributeLine("a2", "s1", "status", `"ended"`, 0),
+			}, "\n") + "\n",
+			problems: []string{
+				"line 2: warning: createdAt 2023-01-01T00:00:01Z is before the previous record",
+				"line 3: warning: createdAt 2023-01-01T00:00:00Z is before the previous record",
+			},
+		},
+		{
+			name:     "invalid createdAt",
+			content:  `{"kind":"Scope","obj":{"id":"s1","createdAt":"yesterday"}}` + "\n",
+			problems: []string{"line 1: error: invalid createdAt: yesterday"},
+			dropped:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var problems []string
+
+			summary, err := Check(strings.NewReader(tt.content), func(p *Problem) {
+				problems = append(problems, p.String())
+			})
+			if err != nil {
+				t.Fatalf("check: %v", err)
+			}
+
+			if len(problems) != len(tt.problems) {
+				t.Fatalf("got problems %q, want %q", problems, tt.problems)
+			}
+
+			for i, want := range tt.problems {
+				if !strings.HasPrefix(problems[i], want) {
+					t.Errorf("got problem %q, want %q", problems[i], want)
+				}
+			}
+
+			if summary.Dropped != tt.dropped || summary.Errors != tt.dropped {
+				t.Errorf("got %d dropped and %d errors, want %d", summary.Dropped, summary.Errors, tt.dropped)
+			}
+
+			var repaired, quarantine bytes.Buffer
+
+			summary, err = Repair(strings.NewReader(tt.content), &repaired, &quarantine, func(*Problem) {})
+			if err != nil {
+				t.Fatalf("repair: %v", err)
+			}
+
+			if n := strings.Count(quarantine.String(), "\n"); n != tt.dropped || summary.Dropped != tt.dropped {
+				t.Errorf("got %d quarantined lines, %d dropped, want %d", n, summary.Dropped, tt.dropped)
+			}
+
+			if n := strings.Count(repaired.String(), "\n"); n != summary.Lines-tt.dropped {
+				t.Errorf("got %d repaired lines, want %d", n, summary.Lines-tt.dropped)
+			}
+
+			summary, err = Check(&repaired, func(p *Problem) {
+				if p.Fatal {
+					t.Errorf("repaired file: %s", p)
+				}
+			})
+			if err != nil || summary.Errors != 0 {
+				t.Errorf("check repaired file: %v, %d errors", err, summary.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Compact rewrites the tajriba file read from r to w, keeping only the last
+// version of non-vector attributes, so the final state of the data is
+// unchanged. If before is not zero, only versions created before it are
+// dropped. Dropped versions are written to archive, if not nil.
+//
+// The file is read twice: first to find the last version of attributes, then
+// to write the compacted file. It must not contain errors, see Check.
+func Compact(r io.ReadSeeker, w, archive io.Writer, before time.Time) (*Summary, error) {
+	last := make(map[string]int)
+
+	_, err := eachRecord(r, func(line int, _ []byte, rec *record, obj *object) error {
+		if key, ok := compactKey(rec, obj); ok {
+			last[key] = line
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, "seek data file")
+	}
+
+	var dropped int
+
+	summary, err := eachRecord(r, func(line int, raw []byte, rec *record, obj *object) error {
+		out := w
+
+		if key, ok := compactKey(rec, obj); ok && last[key] != line {
+			if before.IsZero() || createdBefore(obj, before) {
+				dropped++
+				out = archive
+			}
+		}
+
+		if out == nil {
+			return nil
+		}
+
+		_, err := out.Write(raw)
+
+		return errors.Wrap(err, "write line")
+	})
+	if summary != nil {
+		summary.Dropped = dropped
+	}
+
+	return summary, err
+}
+
+// compactKey returns the key identifying the attribute of a record, if it is
+// a non-vector attribute.
+func compactKey(rec *record, obj *object) (string, bool) {
+	if rec == nil || rec.Kind != "Attribute" || obj.Vector || obj.NodeID == nil {
+		return "", false
+	}
+
+	key, ok := obj.Key.(string)
+	if !ok {
+		return "", false
+	}
+
+	return *obj.NodeID + "\x00" + key, true
+}
+
+func createdBefore(obj *object, before time.Time) bool {
+	if obj.CreatedAt == nil {
+		return false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, *obj.CreatedAt)
+	if err != nil {
+		return false
+	}
+
+	return t.Before(before)
+}
+
+// eachRecord calls fn with every line of r, its line number and its record.
+// The line always ends with a newline. Records are nil for empty lines.
+func eachRecord(r io.Reader, fn func(line int, raw []byte, rec *record, obj *object) error) (*Summary, error) {
+	summary := &Summary{}
+	br := bufio.NewReaderSize(r, 256*1024)
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			summary.Lines++
+
+			if !bytes.HasSuffix(line, []byte("\n")) {
+				line = append(line, '\n')
+			}
+
+			var (
+				rec *record
+				obj *object
+			)
+
+			if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+				rec = &record{}
+				obj = &object{}
+
+				if jerr := json.Unmarshal(trimmed, rec); jerr != nil {
+					return summary, errors.Errorf("line %d: invalid JSON, check the data file", summary.Lines)
+				}
+
+				if jerr := json.Unmarshal(rec.Obj, obj); jerr != nil {
+					return summary, errors.Errorf("line %d: invalid record, check the data file", summary.Lines)
+				}
+
+				summary.Records++
+
+				switch rec.Kind {
+				case "Scope":
+					summary.Scopes++
+				case "Attribute":
+					summary.Attributes++
+				}
+			}
+
+			if ferr := fn(summary.Lines, line, rec, obj); ferr != nil {
+				return summary, ferr
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return summary, nil
+		}
+
+		if err != nil {
+			return summary, errors.Wrap(err, "read line")
+		}
+	}
+}
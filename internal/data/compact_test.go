@@ -0,0 +1,148 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replay returns the final state of the tajriba file: the scopes, and the
+// values of the attributes by scope, key and vector index. Attributes written
+// without a value are deleted.
+func replay(t *testing.T, content string) map[string]string {
+	t.Helper()
+
+	state := make(map[string]string)
+
+	s := bufio.NewScanner(strings.NewReader(content))
+	for s.Scan() {
+		var rec struct {
+			Kind string `json:"kind"`
+			Obj  struct {
+				ID     string          `json:"id"`
+				NodeID string          `json:"nodeID"`
+				Key    string          `json:"key"`
+				Val    json.RawMessage `json:"val"`
+				Vector bool            `json:"vector"`
+				Index  int             `json:"index"`
+			} `json:"obj"`
+		}
+
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			t.Fatalf("replay: %v", err)
+		}
+
+		obj := rec.Obj
+
+		if rec.Kind == "Scope" {
+			state[obj.ID] = "scope"
+
+			continue
+		}
+
+		key := obj.NodeID + "." + obj.Key
+		if obj.Vector {
+			key += fmt.Sprintf("[%d]", obj.Index)
+		}
+
+		if obj.Val == nil {
+			delete(state, key)
+		} else {
+			state[key] = string(obj.Val)
+		}
+	}
+
+	return state
+}
+
+func deleteLine(id, nodeID, key string, sec int) string {
+	return fmt.Sprintf(`{"kind":"Attribute","obj":{"id":%q,"key":%q,"nodeID":%q,"createdAt":"2023-01-01T00:00:%02dZ"}}`,
+		id, key, nodeID, sec)
+}
+
+func TestCompact(t *testing.T) {
+	content := strings.Join([]string{
+		scopeLine("s1", 0),
+		attributeLine("a1", "s1", "status", `"created"`, 1),
+		attributeLine("a2", "s1", "status", `"running"`, 2),
+		vectorLine("a3", "s1", "chat", `"hi"`, `,"index":0`, 3),
+		vectorLine("a4", "s1", "chat", `"yo"`, `,"index":1`, 4),
+		vectorLine("a5", "s1", "chat", `"hey"`, `,"index":0`, 5),
+		attributeLine("a6", "s1", "draft", `"text"`, 6),
+		scopeLine("s2", 7),
+		attributeLine("a7", "s2", "status", `"created"`, 8),
+		deleteLine("a8", "s1", "draft", 9),
+		attributeLine("a9", "s1", "status", `"ended"`, 10),
+		attributeLine("a10", "s2", "status", `"running"`, 11),
+	}, "\n") + "\n"
+
+	tests := []struct {
+		name    string
+		before  time.Time
+		dropped int
+	}{
+		{
+			name:    "all versions",
+			dropped: 4,
+		},
+		{
+			name:    "versions before",
+			before:  time.Date(2023, 1, 1, 0, 0, 2, 0, time.UTC),
+			dropped: 1,
+		},
+		{
+			name:    "versions before the deletion",
+			before:  time.Date(2023, 1, 1, 0, 0, 8, 0, time.UTC),
+			dropped: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var compacted, archive bytes.Buffer
+
+			summary, err := Compact(strings.NewReader(content), &compacted, &archive, tt.before)
+			if err != nil {
+				t.Fatalf("compact: %v", err)
+			}
+
+			if summary.Dropped != tt.dropped {
+				t.Errorf("got %d dropped, want %d", summary.Dropped, tt.dropped)
+			}
+
+			if got, want := replay(t, compacted.String()), replay(t, content); !reflect.DeepEqual(got, want) {
+				t.Errorf("got state %v, want %v", got, want)
+			}
+
+			if _, ok := replay(t, compacted.String())["s1.draft"]; ok {
+				t.Error("got deleted draft key")
+			}
+
+			lines := strings.Count(compacted.String(), "\n")
+			if archived := strings.Count(archive.String(), "\n"); archived != tt.dropped || lines+archived != summary.Lines {
+				t.Errorf("got %d compacted and %d archived lines, want %d", lines, archived, summary.Lines)
+			}
+
+			check, err := Check(&compacted, func(p *Problem) {
+				t.Errorf("compacted file: %s", p)
+			})
+			if err != nil || check.Errors != 0 {
+				t.Errorf("check compacted file: %v", err)
+			}
+		})
+	}
+}
+
+func TestCompactInvalid(t *testing.T) {
+	content := scopeLine("s1", 0) + "\n" + `{"kind":"Attribute",` + "\n"
+
+	_, err := Compact(strings.NewReader(content), &bytes.Buffer{}, nil, time.Time{})
+	if err == nil || !strings.Contains(err.Error(), "line 2: invalid JSON") {
+		t.Errorf("got error %v, want invalid JSON on line 2", err)
+	}
+}
@@ -0,0 +1,303 @@
+package export
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Anonymization actions.
+const (
+	// ActionHash replaces values with their HMAC-SHA256, keyed with the salt.
+	ActionHash = "hash"
+	// ActionDrop removes the attributes from the export.
+	ActionDrop = "drop"
+	// ActionRedact replaces the text matching a pattern in string values.
+	ActionRedact = "redact"
+	// ActionBucket replaces numeric values with the range they fall in.
+	ActionBucket = "bucket"
+)
+
+// SaltEnv is the environment variable holding the salt of hashed values, if
+// not set in the rules file.
+const SaltEnv = "EMPIRICA_EXPORT_SALT"
+
+// defaultReplacement replaces redacted text if the rule has no replacement.
+const defaultReplacement = "[REDACTED]"
+
+// Rules anonymize attribute values during the export. Each attribute is
+// anonymized by the first rule matching its kind and key, if any.
+type Rules struct {
+	// Salt is mixed in hashed values, so they cannot be reversed by hashing
+	// known values. It should be kept secret and stay the same for a project,
+	// for hashed values to match across exports.
+	Salt  string  `yaml:"salt,omitempty"`
+	Rules []*Rule `validate:"dive" yaml:"rules"`
+}
+
+// Rule is an anonymization rule for the attributes with a key matching Key,
+// on Scopes of kind Kind, or of any kind if empty. Key is a pattern, as in
+// path.Match.
+type Rule struct {
+	Kind   string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	Key    string `json:"key" validate:"required" yaml:"key"`
+	Action string `json:"action" validate:"required,oneof=hash drop redact bucket" yaml:"action"`
+
+	// Pattern is the regular expression of the text to redact. All of the
+	// value is redacted if empty. Replacement replaces the text, "[REDACTED]"
+	// if empty.
+	Pattern     string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Replacement string `json:"replacement,omitempty" yaml:"replacement,omitempty"`
+
+	// Size is the width of buckets, starting at 0. Alternatively, Bounds are
+	// the limits between buckets.
+	Size   float64   `json:"size,omitempty" validate:"min=0" yaml:"size,omitempty"`
+	Bounds []float64 `json:"bounds,omitempty" yaml:"bounds,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// LoadRules reads the anonymization rules from a YAML file.
+func LoadRules(filename string) (*Rules, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "read rules file")
+	}
+
+	r := &Rules{}
+
+	if err := yaml.Unmarshal(b, r); err != nil {
+		return nil, errors.Wrap(err, "parse rules file")
+	}
+
+	if r.Salt == "" {
+		r.Salt = os.Getenv(SaltEnv)
+	}
+
+	if err := r.init(); err != nil {
+		return nil, errors.Wrap(err, "invalid rules file")
+	}
+
+	return r, nil
+}
+
+// init validates the rules and compiles their patterns.
+func (r *Rules) init() error {
+	if err := validator.New().Struct(r); err != nil {
+		return err
+	}
+
+	for i, rule := range r.Rules {
+		if _, err := path.Match(rule.Key, ""); err != nil {
+			return errors.Wrapf(err, "rule %d: key pattern %q", i+1, rule.Key)
+		}
+
+		switch rule.Action {
+		case ActionHash:
+			if r.Salt == "" {
+				return errors.Errorf("rule %d: hash requires a salt (set salt or %s)", i+1, SaltEnv)
+			}
+		case ActionRedact:
+			if rule.Pattern == "" {
+				continue
+			}
+
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return errors.Wrapf(err, "rule %d: pattern", i+1)
+			}
+
+			rule.re = re
+		case ActionBucket:
+			if rule.Size == 0 && len(rule.Bounds) == 0 {
+				return errors.Errorf("rule %d: bucket requires size or bounds", i+1)
+			}
+
+			sort.Float64s(rule.Bounds)
+		}
+	}
+
+	return nil
+}
+
+func (r *Rules) rule(kind, key string) *Rule {
+	for _, rule := range r.Rules {
+		if rule.Kind != "" && camelCase(rule.Kind) != camelCase(kind) {
+			continue
+		}
+
+		if ok, _ := path.Match(rule.Key, key); ok {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// apply anonymizes the JSON encoded value of an attribute. It returns false if
+// the attribute is dropped.
+func (r *Rules) apply(kind, key, val string) (string, bool) {
+	if r == nil {
+		return val, true
+	}
+
+	rule := r.rule(kind, key)
+	if rule == nil {
+		return val, true
+	}
+
+	switch rule.Action {
+	case ActionDrop:
+		return "", false
+	case ActionHash:
+		return r.hash(val), true
+	case ActionRedact:
+		return rule.redact(val), true
+	case ActionBucket:
+		return rule.bucket(val), true
+	default:
+		return val, true
+	}
+}
+
+// hash returns the hash of a string value, or of the JSON encoding of other
+// values. Null values are kept.
+func (r *Rules) hash(val string) string {
+	v := decodeValue(val)
+	if v == nil {
+		return val
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		s = compactJSON(val)
+	}
+
+	mac := hmac.New(sha256.New, []byte(r.Salt))
+	mac.Write([]byte(s))
+
+	return encodeJSON(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// redact redacts all the strings in the value, including in objects and
+// arrays.
+func (rule *Rule) redact(val string) string {
+	v := decodeValue(val)
+	if v == nil {
+		return val
+	}
+
+	return encodeJSON(rule.redactValue(v))
+}
+
+func (rule *Rule) redactValue(v interface{}) interface{} {
+	replacement := rule.Replacement
+	if replacement == "" {
+		replacement = defaultReplacement
+	}
+
+	switch t := v.(type) {
+	case string:
+		if rule.re == nil {
+			return replacement
+		}
+
+		return rule.re.ReplaceAllString(t, replacement)
+	case []interface{}:
+		for i, e := range t {
+			t[i] = rule.redactValue(e)
+		}
+
+		return t
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = rule.redactValue(e)
+		}
+
+		return t
+	default:
+		return v
+	}
+}
+
+// bucket returns the bucket of a number, or of a string holding a number, as
+// a string: "20-30", "<18" or ">=65". Other values are replaced with null.
+func (rule *Rule) bucket(val string) string {
+	var f float64
+
+	switch t := decodeValue(val).(type) {
+	case json.Number:
+		n, err := t.Float64()
+		if err != nil {
+			return "null"
+		}
+
+		f = n
+	case string:
+		n, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return "null"
+		}
+
+		f = n
+	default:
+		return "null"
+	}
+
+	if len(rule.Bounds) == 0 {
+		low := math.Floor(f/rule.Size) * rule.Size
+
+		return encodeJSON(formatFloat(low) + "-" + formatFloat(low+rule.Size))
+	}
+
+	if f < rule.Bounds[0] {
+		return encodeJSON("<" + formatFloat(rule.Bounds[0]))
+	}
+
+	for i := 1; i < len(rule.Bounds); i++ {
+		if f < rule.Bounds[i] {
+			return encodeJSON(formatFloat(rule.Bounds[i-1]) + "-" + formatFloat(rule.Bounds[i]))
+		}
+	}
+
+	return encodeJSON(">=" + formatFloat(rule.Bounds[len(rule.Bounds)-1]))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func encodeJSON(v interface{}) string {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(v); err != nil {
+		return "null"
+	}
+
+	return string(bytes.TrimSpace(buf.Bytes()))
+}
+
+func compactJSON(val string) string {
+	var buf bytes.Buffer
+
+	if err := json.Compact(&buf, []byte(val)); err != nil {
+		return val
+	}
+
+	return buf.String()
+}
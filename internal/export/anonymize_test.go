@@ -0,0 +1,196 @@
+package export
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// hmacHex returns the JSON string of the HMAC-SHA256 of s with the salt.
+func hmacHex(salt, s string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(s))
+
+	return `"` + hex.EncodeToString(mac.Sum(nil)) + `"`
+}
+
+func TestRulesApply(t *testing.T) {
+	rules := &Rules{
+		Salt: "salt",
+		Rules: []*Rule{
+			{Kind: "player", Key: "participantIdentifier", Action: ActionHash},
+			{Key: "email", Action: ActionDrop},
+			{Key: "chat", Action: ActionRedact, Pattern: `[\w.]+@[\w.]+`, Replacement: "[email]"},
+			{Key: "notes*", Action: ActionRedact},
+			{Key: "age", Action: ActionBucket, Bounds: []float64{50, 18, 25}},
+			{Key: "income", Action: ActionBucket, Size: 10000},
+			{Key: "*ID", Action: ActionHash},
+		},
+	}
+
+	if err := rules.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		kind string
+		key  string
+		val  string
+		want string
+		drop bool
+	}{
+		{"hash string", "player", "participantIdentifier", `"ann"`, hmacHex("salt", "ann"), false},
+		{"hash other kind", "game", "participantIdentifier", `"ann"`, `"ann"`, false},
+		{"hash number as json", "player", "workerID", `12`, hmacHex("salt", "12"), false},
+		{"hash object as compact json", "player", "workerID", `{ "a": 1 }`, hmacHex("salt", `{"a":1}`), false},
+		{"hash null", "player", "workerID", `null`, `null`, false},
+		{"drop", "game", "email", `"ann@example.com"`, "", true},
+		{"redact pattern", "player", "chat", `"mail ann@example.com"`, `"mail [email]"`, false},
+		{"redact nested", "player", "chat", `{"text":["a@b.c"],"n":1}`, `{"n":1,"text":["[email]"]}`, false},
+		{"redact all", "player", "notesPrivate", `"secret"`, `"[REDACTED]"`, false},
+		{"redact keeps numbers", "player", "notes", `3`, `3`, false},
+		{"bucket under bounds", "player", "age", `17`, `"<18"`, false},
+		{"bucket between bounds", "player", "age", `18`, `"18-25"`, false},
+		{"bucket over bounds", "player", "age", `50`, `">=50"`, false},
+		{"bucket string number", "player", "age", `"30"`, `"25-50"`, false},
+		{"bucket not a number", "player", "age", `"old"`, `null`, false},
+		{"bucket size", "player", "income", `25500.5`, `"20000-30000"`, false},
+		{"no rule", "player", "name", `"ann"`, `"ann"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := rules.apply(tt.kind, tt.key, tt.val)
+			if ok == tt.drop {
+				t.Fatalf("got kept %v, want %v", ok, !tt.drop)
+			}
+
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		env  string
+		want string
+	}{
+		{
+			name: "salt from environment",
+			yaml: "rules:\n  - key: id\n    action: hash\n",
+			env:  "env-salt",
+		},
+		{
+			name: "hash without salt",
+			yaml: "rules:\n  - key: id\n    action: hash\n",
+			want: "rule 1: hash requires a salt",
+		},
+		{
+			name: "unknown action",
+			yaml: "rules:\n  - key: id\n    action: shuffle\n",
+			want: "invalid rules file",
+		},
+		{
+			name: "missing key",
+			yaml: "rules:\n  - action: drop\n",
+			want: "invalid rules file",
+		},
+		{
+			name: "invalid key pattern",
+			yaml: "rules:\n  - key: '[a'\n    action: drop\n",
+			want: `rule 1: key pattern "[a"`,
+		},
+		{
+			name: "invalid redact pattern",
+			yaml: "rules:\n  - key: chat\n    action: redact\n    pattern: '('\n",
+			want: "rule 1: pattern",
+		},
+		{
+			name: "bucket without size",
+			yaml: "rules:\n  - key: age\n    action: bucket\n",
+			want: "rule 1: bucket requires size or bounds",
+		},
+		{
+			name: "invalid yaml",
+			yaml: "rules: [",
+			want: "parse rules file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(SaltEnv, tt.env)
+
+			filename := filepath.Join(t.TempDir(), "rules.yaml")
+			if err := os.WriteFile(filename, []byte(tt.yaml), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			rules, err := LoadRules(filename)
+
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatalf("load rules: %v", err)
+			case tt.want == "":
+				if rules.Salt != tt.env {
+					t.Errorf("got salt %q, want %q", rules.Salt, tt.env)
+				}
+			case err == nil || !strings.Contains(err.Error(), tt.want):
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportAnonymize(t *testing.T) {
+	tajfile := writeTajfile(t,
+		[][2]string{{"p1", "player"}},
+		[][3]string{
+			{"p1", "participantIdentifier", `"ann"`},
+			{"p1", "email", `"ann@example.com"`},
+			{"p1", "age", `31`},
+		})
+
+	rules := &Rules{
+		Salt: "salt",
+		Rules: []*Rule{
+			{Key: "participantIdentifier", Action: ActionHash},
+			{Key: "email", Action: ActionDrop},
+			{Key: "age", Action: ActionBucket, Size: 10},
+		},
+	}
+
+	if err := rules.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "export.zip")
+	if err := Export(tajfile, filename, &Options{Anonymize: rules, History: true}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	hash := strings.Trim(hmacHex("salt", "ann"), `"`)
+
+	for _, file := range []string{"player.csv", "history/player.csv"} {
+		b := readZipFile(t, filename, file)
+
+		for _, s := range []string{"ann", "example.com", "31"} {
+			if strings.Contains(strings.ReplaceAll(string(b), hash, ""), s) {
+				t.Errorf("got %q in %s", s, file)
+			}
+		}
+	}
+
+	checkRows(t, "player.csv", readZipRows(t, filename, "player.csv"), []map[string]string{
+		{"id": "p1", "participantIdentifier": hash, "age": "30-40"},
+	})
+}
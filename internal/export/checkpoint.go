@@ -0,0 +1,341 @@
+package export
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// checkpointVersion is the version of the checkpoint format. Checkpoints
+	// of other versions are discarded.
+	checkpointVersion = 1
+
+	checkpointFile = "checkpoint.json"
+
+	// runPattern is the pattern of the names of the run files of the
+	// checkpoint.
+	runPattern = "entries-*"
+
+	// checkpointHashSize is the size of the start, and of the end, of the data
+	// read from the tajriba file hashed to recognize the file in later
+	// exports.
+	checkpointHashSize = 64 * 1024
+)
+
+// checkpoint records how much of the tajriba file was read in a previous
+// export. The records read are kept sorted in run files next to the
+// checkpoint, each export adding a run with the records it read.
+type checkpoint struct {
+	Version int `json:"version"`
+
+	// Offset is the number of bytes of the tajriba file read, Seq the number
+	// of records read and LastCreatedAt the creation time of the last one.
+	Offset        int64  `json:"offset"`
+	Seq           uint64 `json:"seq"`
+	LastCreatedAt string `json:"lastCreatedAt"`
+
+	// Hash is the hash of the data read from the tajriba file, see hashRead,
+	// to detect the file was replaced.
+	Hash string `json:"hash"`
+
+	// SHA256 is the state of the SHA-256 of the data read, resumed to hash
+	// only the data added for the manifest.
+	SHA256 []byte `json:"sha256"`
+
+	// Runs are the run files of the records read, by decreasing size.
+	Runs []*checkpointRun `json:"runs"`
+
+	// Relational and Links are the options changing the sorted records.
+	Relational bool `json:"relational"`
+	Links      bool `json:"links"`
+}
+
+// checkpointRun is a file of sorted records of the checkpoint.
+type checkpointRun struct {
+	File string `json:"file"`
+	Size int64  `json:"size"`
+}
+
+// loadIncremental reads the tajriba file from the checkpoint in opts, adds
+// the new records to the records of previous exports and updates the
+// checkpoint. The tajriba file is read from the start if there is no valid
+// checkpoint. Only complete lines are read, a line being written is read by
+// the next export.
+func (d *dataset) loadIncremental(file *os.File) error {
+	dir := d.opts.Checkpoint
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.Wrap(err, "create checkpoint directory")
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat tajriba file")
+	}
+
+	end, err := lastLineEnd(file, stat.Size())
+	if err != nil {
+		return err
+	}
+
+	cp := &checkpoint{
+		Version:    checkpointVersion,
+		Relational: d.opts.Relational,
+		Links:      d.filter.games != nil,
+	}
+
+	prev, err := readCheckpoint(dir)
+	if err != nil {
+		return err
+	}
+
+	if prev != nil {
+		hash, err := hashRead(file, prev.Offset)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case prev.Version != checkpointVersion,
+			prev.Relational != cp.Relational,
+			prev.Links != cp.Links:
+			log.Info().Msg("Export checkpoint options changed, reading all data")
+		case prev.Offset > end || prev.Hash != hash:
+			log.Info().Msg("Tajriba file changed since export checkpoint, reading all data")
+		default:
+			cp.Offset = prev.Offset
+			cp.Seq = prev.Seq
+			cp.LastCreatedAt = prev.LastCreatedAt
+			cp.SHA256 = prev.SHA256
+			cp.Runs = prev.Runs
+
+			log.Info().
+				Int64("offset", prev.Offset).
+				Str("lastCreatedAt", prev.LastCreatedAt).
+				Msg("Resuming export from checkpoint")
+		}
+	}
+
+	// Only the data added is read, and hashed for the manifest.
+	added := io.NewSectionReader(file, cp.Offset, end-cp.Offset)
+	r := newHashReader(added)
+
+	if cp.Offset > 0 {
+		r, err = resumeHashReader(added, cp.Offset, cp.SHA256)
+		if err != nil {
+			return err
+		}
+	}
+
+	s := newSorter(d.dir)
+
+	d.last = cp.LastCreatedAt
+
+	cp.Seq, err = d.read(r, s, cp.Seq, 0)
+	if err != nil {
+		return err
+	}
+
+	d.sourceInfos = append(d.sourceInfos, r.info(d.sources[0].Name))
+
+	cp.Offset = end
+	cp.LastCreatedAt = d.last
+
+	cp.Hash, err = hashRead(file, end)
+	if err != nil {
+		return err
+	}
+
+	cp.SHA256, err = r.state()
+	if err != nil {
+		return err
+	}
+
+	if !s.empty() {
+		run, err := saveRun(dir, s)
+		if err != nil {
+			return err
+		}
+
+		cp.Runs = append(cp.Runs, run)
+	}
+
+	cp.Runs, err = compactRuns(dir, d.dir, cp.Runs)
+	if err != nil {
+		return err
+	}
+
+	// The checkpoint replaces the previous one at once, then the run files
+	// it no longer uses are removed.
+	if err := writeCheckpoint(dir, cp); err != nil {
+		return err
+	}
+
+	removeRuns(dir, cp.Runs)
+
+	files := make([]string, len(cp.Runs))
+	for i, run := range cp.Runs {
+		files[i] = filepath.Join(dir, run.File)
+	}
+
+	return d.reduce(newSorter(d.dir, files...))
+}
+
+// saveRun saves the records of the sorter in a new run file of the checkpoint
+// directory.
+func saveRun(dir string, s *sorter) (*checkpointRun, error) {
+	file, err := os.CreateTemp(dir, runPattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "create checkpoint run")
+	}
+
+	file.Close()
+
+	if err := s.save(file.Name()); err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(file.Name())
+	if err != nil {
+		return nil, errors.Wrap(err, "stat checkpoint run")
+	}
+
+	return &checkpointRun{File: filepath.Base(file.Name()), Size: stat.Size()}, nil
+}
+
+// compactRuns merges the last runs while a run is not more than twice the size
+// of the run following it, so the number of runs, and the number of times a
+// record is merged, is logarithmic in the number of records. tmp is the
+// directory of the temporary files of the merge.
+func compactRuns(dir, tmp string, runs []*checkpointRun) ([]*checkpointRun, error) {
+	for n := len(runs); n >= 2 && runs[n-2].Size <= 2*runs[n-1].Size; n = len(runs) {
+		s := newSorter(tmp, filepath.Join(dir, runs[n-2].File), filepath.Join(dir, runs[n-1].File))
+
+		run, err := saveRun(dir, s)
+		if err != nil {
+			return nil, err
+		}
+
+		runs = append(runs[:n-2], run)
+	}
+
+	return runs, nil
+}
+
+// removeRuns removes the run files of the checkpoint directory that are not
+// in runs, left by previous exports.
+func removeRuns(dir string, runs []*checkpointRun) {
+	files, err := filepath.Glob(filepath.Join(dir, runPattern))
+	if err != nil {
+		return
+	}
+
+	keep := make(map[string]bool, len(runs))
+	for _, run := range runs {
+		keep[run.File] = true
+	}
+
+	for _, file := range files {
+		if !keep[filepath.Base(file)] {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				log.Warn().Err(err).Str("file", file).Msg("export: remove checkpoint run")
+			}
+		}
+	}
+}
+
+func readCheckpoint(dir string) (*checkpoint, error) {
+	b, err := os.ReadFile(filepath.Join(dir, checkpointFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "read checkpoint")
+	}
+
+	cp := &checkpoint{}
+
+	if err := json.Unmarshal(b, cp); err != nil {
+		log.Warn().Err(err).Msg("Invalid export checkpoint, reading all data")
+
+		return nil, nil
+	}
+
+	for _, run := range cp.Runs {
+		if _, err := os.Stat(filepath.Join(dir, run.File)); err != nil {
+			log.Warn().Str("file", run.File).Msg("Missing export checkpoint run, reading all data")
+
+			return nil, nil
+		}
+	}
+
+	return cp, nil
+}
+
+func writeCheckpoint(dir string, cp *checkpoint) error {
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encode checkpoint")
+	}
+
+	tmp := filepath.Join(dir, checkpointFile+".tmp")
+
+	if err := os.WriteFile(tmp, b, fileDefaultPerms); err != nil {
+		return errors.Wrap(err, "write checkpoint")
+	}
+
+	return errors.Wrap(os.Rename(tmp, filepath.Join(dir, checkpointFile)), "write checkpoint")
+}
+
+// hashRead returns the hash of the start and of the end of the first offset
+// bytes of the file.
+func hashRead(file *os.File, offset int64) (string, error) {
+	size := offset
+	if size > checkpointHashSize {
+		size = checkpointHashSize
+	}
+
+	h := sha256.New()
+
+	for _, start := range []int64{0, offset - size} {
+		if _, err := io.Copy(h, io.NewSectionReader(file, start, size)); err != nil {
+			return "", errors.Wrap(err, "read tajriba file")
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lastLineEnd returns the offset following the last newline of the file.
+func lastLineEnd(file *os.File, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+
+	for end := size; end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+
+		n, err := file.ReadAt(buf[:end-start], start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, errors.Wrap(err, "read tajriba file")
+		}
+
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+
+		end = start
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,229 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readZipManifest(t *testing.T, filename string) *Manifest {
+	t.Helper()
+
+	z, err := zip.OpenReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	f, err := z.Open(manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	m := &Manifest{}
+	if err := json.NewDecoder(f).Decode(m); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func appendFile(t *testing.T, filename, content string) {
+	t.Helper()
+
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncrementalExport(t *testing.T) {
+	scopes := [][2]string{{"g1", "game"}, {"p1", "player"}, {"p2", "player"}}
+
+	var attributes [][3]string
+
+	for i := 0; i < 90; i++ {
+		scope := []string{"g1", "p1", "p2"}[i%3]
+		attributes = append(attributes, [3]string{scope, fmt.Sprintf("key%d", i%7), fmt.Sprint(i)})
+	}
+
+	lines := tajLines(scopes, attributes)
+	content := strings.Join(lines, "\n") + "\n"
+	dir := t.TempDir()
+
+	full := filepath.Join(dir, "full.json")
+	if err := os.WriteFile(full, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	want := filepath.Join(dir, "full.zip")
+	if err := Export(full, want, &Options{Source: "tajriba.json"}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	// The tajriba file grows between exports, the second export reads a line
+	// being written, completed before the last export.
+	third := len(content) / 3
+	parts := []string{content[:third], content[third : 2*third], content[2*third:]}
+
+	tajfile := filepath.Join(dir, "tajriba.json")
+	checkpoint := filepath.Join(dir, "checkpoint")
+
+	var got string
+
+	for i, part := range parts {
+		appendFile(t, tajfile, part)
+
+		got = filepath.Join(dir, fmt.Sprintf("incremental%d.zip", i))
+
+		opts := &Options{Source: "tajriba.json", Checkpoint: checkpoint}
+		if err := Export(tajfile, got, opts); err != nil {
+			t.Fatalf("export %d: %v", i, err)
+		}
+	}
+
+	wm, gm := readZipManifest(t, want), readZipManifest(t, got)
+
+	if len(gm.Sources) != 1 || *gm.Sources[0] != *wm.Sources[0] {
+		t.Errorf("got source %+v, want %+v", gm.Sources[0], wm.Sources[0])
+	}
+
+	files := make(map[string]FileInfo, len(wm.Files))
+	for _, f := range wm.Files {
+		files[f.Name] = *f
+	}
+
+	if len(gm.Files) != len(wm.Files) {
+		t.Errorf("got %d files, want %d", len(gm.Files), len(wm.Files))
+	}
+
+	for _, f := range gm.Files {
+		if w, ok := files[f.Name]; !ok || *f != w {
+			t.Errorf("got file %+v, want %+v", f, w)
+		}
+	}
+
+	cp, err := readCheckpoint(checkpoint)
+	if err != nil || cp == nil {
+		t.Fatalf("read checkpoint: %v", err)
+	}
+
+	runs, err := filepath.Glob(filepath.Join(checkpoint, runPattern))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(runs) != len(cp.Runs) {
+		t.Errorf("got %d run files, want the %d runs of the checkpoint", len(runs), len(cp.Runs))
+	}
+}
+
+func TestCompactRuns(t *testing.T) {
+	dir := t.TempDir()
+
+	var runs []*checkpointRun
+
+	for i := 0; i < 20; i++ {
+		s := newSorter(dir)
+
+		for j := 0; j < 10; j++ {
+			seq := uint64(i*10 + j)
+			if err := s.add(&entry{ScopeID: fmt.Sprintf("s%d", seq%5), Seq: seq, Key: "k"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		run, err := saveRun(dir, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runs, err = compactRuns(dir, dir, append(runs, run))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for k := 1; k < len(runs); k++ {
+			if runs[k-1].Size <= 2*runs[k].Size {
+				t.Fatalf("after %d runs: run %d of size %d not more than twice run %d of size %d",
+					i+1, k-1, runs[k-1].Size, k, runs[k].Size)
+			}
+		}
+	}
+
+	if len(runs) > 5 {
+		t.Errorf("got %d runs of 20 added, want at most 5", len(runs))
+	}
+
+	files := make([]string, len(runs))
+	for i, run := range runs {
+		files[i] = filepath.Join(dir, run.File)
+	}
+
+	var n int
+
+	err := newSorter(dir, files...).each(func(*entry) error {
+		n++
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 200 {
+		t.Errorf("got %d entries, want 200", n)
+	}
+}
+
+func TestIncrementalExportErrors(t *testing.T) {
+	tajfile := writeTajfile(t, [][2]string{{"p1", "player"}}, [][3]string{{"p1", "email", `"ann@example.com"`}})
+
+	tests := []struct {
+		name string
+		opts *Options
+		want string
+	}{
+		{
+			name: "anonymize",
+			opts: &Options{Anonymize: &Rules{Rules: []*Rule{{Key: "email", Action: "drop"}}}},
+			want: "cannot anonymize",
+		},
+		{
+			name: "merge",
+			opts: &Options{Merge: []*Source{{File: tajfile, Name: "copy"}}},
+			want: "cannot merge",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			checkpoint := filepath.Join(dir, "checkpoint")
+			tt.opts.Checkpoint = checkpoint
+
+			err := Export(tajfile, filepath.Join(dir, "export.zip"), tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+
+			// Nothing read from the tajriba file is kept.
+			if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+				t.Errorf("checkpoint directory created")
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package export
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// codebookExamples is the maximum number of example values of a key.
+	codebookExamples = 3
+
+	// codebookExampleSize is the maximum size of the JSON encoding of an
+	// example value, larger values are not used as examples.
+	codebookExampleSize = 256
+)
+
+// KeyInfo describes the values of an attribute key on Scopes of a kind, as
+// observed in all the changes of the attribute.
+type KeyInfo struct {
+	Key string `json:"key"`
+
+	// Relation is true for the foreign keys of relational exports.
+	Relation bool `json:"relation,omitempty"`
+
+	// Types are the JSON types of the values: null, boolean, integer, number,
+	// string, object or array. For vectors, types of the elements.
+	Types  []string `json:"types"`
+	Vector bool     `json:"vector"`
+
+	// Scopes is the number of Scopes with the key, Changes the number of
+	// changes of the attribute on all Scopes.
+	Scopes  int `json:"scopes"`
+	Changes int `json:"changes"`
+
+	FirstChangedAt string `json:"firstChangedAt"`
+	LastChangedAt  string `json:"lastChangedAt"`
+
+	Examples []json.RawMessage `json:"examples"`
+
+	types       map[string]bool
+	first, last time.Time
+}
+
+// observe records a change of the attribute.
+func (i *KeyInfo) observe(vector bool, val, createdAt string) {
+	i.Changes++
+	i.Vector = i.Vector || vector
+
+	v := decodeValue(val)
+
+	name := typeName(v)
+	if !i.types[name] {
+		i.types[name] = true
+		i.Types = append(i.Types, name)
+		sort.Strings(i.Types)
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, createdAt); err == nil {
+		if i.first.IsZero() || t.Before(i.first) {
+			i.first = t
+			i.FirstChangedAt = createdAt
+		}
+
+		if t.After(i.last) {
+			i.last = t
+			i.LastChangedAt = createdAt
+		}
+	}
+
+	if v == nil || len(i.Examples) >= codebookExamples {
+		return
+	}
+
+	example := jsonValue(val)
+	if len(example) > codebookExampleSize {
+		return
+	}
+
+	for _, e := range i.Examples {
+		if string(e) == example {
+			return
+		}
+	}
+
+	i.Examples = append(i.Examples, json.RawMessage(example))
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+
+	switch typeOf(v) {
+	case typeNull:
+		return "null"
+	case typeBool:
+		return "boolean"
+	case typeInt:
+		return "integer"
+	case typeFloat:
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+// keyInfo returns the KeyInfo of the key, created if needed.
+func (k *Kind) keyInfo(key string) *KeyInfo {
+	info, ok := k.infos[key]
+	if !ok {
+		info = &KeyInfo{
+			Key:      key,
+			Types:    []string{},
+			Examples: []json.RawMessage{},
+			types:    make(map[string]bool),
+		}
+		k.infos[key] = info
+	}
+
+	return info
+}
+
+// Codebook describes the foreign keys (in relational exports) and the keys of
+// the kind, in the order of the columns of the export.
+func (k *Kind) Codebook() []*KeyInfo {
+	infos := make([]*KeyInfo, 0, len(k.Relations)+len(k.Keys))
+
+	for _, key := range k.Relations {
+		if info, ok := k.infos[key]; ok {
+			info.Relation = true
+			infos = append(infos, info)
+		}
+	}
+
+	for _, key := range k.Keys {
+		if info, ok := k.infos[key]; ok {
+			infos = append(infos, info)
+		}
+	}
+
+	return infos
+}
+
+// writeCodebook writes the codebook of the kind in the codebook/<kind>.json
+// file of the zip file.
+func writeCodebook(z *Zip, kind *Kind) error {
+	zf, err := z.Create("codebook/" + camelCase(kind.Name) + ".json")
+	if err != nil {
+		return err
+	}
+
+	keys := kind.Codebook()
+	zf.Rows = len(keys)
+
+	enc := json.NewEncoder(zf)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	return errors.Wrap(enc.Encode(struct {
+		Kind  string     `json:"kind"`
+		Count int        `json:"count"`
+		Keys  []*KeyInfo `json:"keys"`
+	}{
+		Kind:  kind.Name,
+		Count: kind.Count,
+		Keys:  keys,
+	}), "write codebook")
+}
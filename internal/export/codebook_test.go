@@ -0,0 +1,104 @@
+package export
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportCodebook(t *testing.T) {
+	large := `"` + strings.Repeat("x", codebookExampleSize) + `"`
+
+	tajfile := writeTajfile(t,
+		[][2]string{{"p1", "player"}, {"p2", "player"}, {"pg1", "playerGame"}},
+		[][3]string{
+			{"p1", "score", `1`},
+			{"p1", "score", `1`},
+			{"p2", "score", `2.5`},
+			{"p2", "score", `"none"`},
+			{"p1", "score", `4`},
+			{"p1", "tags[0]", `"a"`},
+			{"p1", "tags[1]", `true`},
+			{"p1", "bio", large},
+			{"p2", "bio", `null`},
+			{"p1", "playerGameID-g1", `"pg1"`},
+		})
+
+	tests := []struct {
+		name string
+		kind string
+		opts *Options
+		want []KeyInfo
+	}{
+		{
+			name: "keys of players",
+			kind: "player",
+			opts: &Options{},
+			want: []KeyInfo{
+				{
+					Key: "bio", Types: []string{"null", "string"}, Scopes: 2, Changes: 2,
+					FirstChangedAt: "2023-01-01T00:00:07Z", LastChangedAt: "2023-01-01T00:00:08Z",
+					Examples: []json.RawMessage{},
+				},
+				{
+					Key: "score", Types: []string{"integer", "number", "string"}, Scopes: 2, Changes: 5,
+					FirstChangedAt: "2023-01-01T00:00:00Z", LastChangedAt: "2023-01-01T00:00:04Z",
+					Examples: []json.RawMessage{json.RawMessage(`1`), json.RawMessage(`4`), json.RawMessage(`2.5`)},
+				},
+				{
+					Key: "tags", Types: []string{"boolean", "string"}, Vector: true, Scopes: 1, Changes: 2,
+					FirstChangedAt: "2023-01-01T00:00:05Z", LastChangedAt: "2023-01-01T00:00:06Z",
+					Examples: []json.RawMessage{json.RawMessage(`"a"`), json.RawMessage(`true`)},
+				},
+			},
+		},
+		{
+			name: "foreign keys first",
+			kind: "playerGame",
+			opts: &Options{Relational: true},
+			want: []KeyInfo{
+				{
+					Key: "gameID", Relation: true, Types: []string{"string"}, Scopes: 1, Changes: 1,
+					FirstChangedAt: "2023-01-01T00:00:09Z", LastChangedAt: "2023-01-01T00:00:09Z",
+					Examples: []json.RawMessage{json.RawMessage(`"g1"`)},
+				},
+				{
+					Key: "playerID", Relation: true, Types: []string{"string"}, Scopes: 1, Changes: 1,
+					FirstChangedAt: "2023-01-01T00:00:09Z", LastChangedAt: "2023-01-01T00:00:09Z",
+					Examples: []json.RawMessage{json.RawMessage(`"p1"`)},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "export.zip")
+			if err := Export(tajfile, filename, tt.opts); err != nil {
+				t.Fatalf("export: %v", err)
+			}
+
+			var codebook struct {
+				Kind  string    `json:"kind"`
+				Count int       `json:"count"`
+				Keys  []KeyInfo `json:"keys"`
+			}
+
+			if err := json.Unmarshal(readZipFile(t, filename, "codebook/"+tt.kind+".json"), &codebook); err != nil {
+				t.Fatalf("read codebook: %v", err)
+			}
+
+			if codebook.Kind != tt.kind {
+				t.Errorf("got kind %s, want %s", codebook.Kind, tt.kind)
+			}
+
+			if !reflect.DeepEqual(codebook.Keys, tt.want) {
+				got, _ := json.Marshal(codebook.Keys)
+				want, _ := json.Marshal(tt.want)
+				t.Errorf("got keys\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
@@ -1,91 +1,277 @@
 package export
 
 import (
-	"archive/zip"
 	"encoding/csv"
-	"os"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
-	"github.com/rs/zerolog/log"
 )
 
-const fileDefaultPerms = 0o644
+var csvFormat = &Format{
+	Name:      FormatCSV,
+	Ext:       "zip",
+	NewWriter: newCSVWriter,
+}
+
+// ExportCSV exports the data of the tajriba file to a zip file containing a
+// CSV file per kind of Scope.
+func ExportCSV(tajfile, filename string, opts *Options) error {
+	return exportFormat(tajfile, filename, opts, csvFormat)
+}
 
-func ExportCSV(tajfile, filename string) error {
-	kinds, err := prepare(tajfile)
+func newCSVWriter(filename string, opts *Options) (Writer, error) {
+	return NewZipWriter(filename, func(z *Zip, kind *Kind) error {
+		if err := writeKindCSV(z, kind, opts.Vectors); err != nil {
+			return err
+		}
+
+		if opts.Vectors == VectorsLong && len(kind.vectors) > 0 {
+			if err := writeVectorsCSV(z, kind); err != nil {
+				return err
+			}
+		}
+
+		if kind.HasHistory() {
+			return writeHistoryCSV(z, kind)
+		}
+
+		return nil
+	})
+}
+
+func writeKindCSV(z *Zip, kind *Kind, vectors string) error {
+	zf, err := z.Create(camelCase(kind.Name) + ".csv")
 	if err != nil {
-		return errors.Wrap(err, "prepare export")
+		return err
 	}
 
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fileDefaultPerms)
-	if err != nil {
-		return errors.Wrap(err, "open file")
+	w := csv.NewWriter(zf)
+
+	fields := []string{"id"}
+	if kind.Merged {
+		fields = append(fields, "source")
 	}
-	defer file.Close()
 
-	z := zip.NewWriter(file)
+	fields = append(fields, kind.Relations...)
 
-	for _, kind := range kinds {
-		log.Info().
-			Int("count", len(kind.Scopes)).
-			Int("keys", len(kind.Keys)).
-			Msgf("Exporting %s", kind.Name)
+	for _, key := range kind.Keys {
+		size := kind.VectorSize(key)
 
-		zf, err := z.Create(camelCase(kind.Name) + ".csv")
-		if err != nil {
-			return errors.Wrap(err, "create file in zip")
+		switch {
+		case size > 0 && vectors == VectorsLong:
+			continue
+		case size > 0 && vectors == VectorsWide:
+			for i := 0; i < size; i++ {
+				fields = append(fields, key+"_"+strconv.Itoa(i))
+			}
+		default:
+			fields = append(fields, key)
 		}
 
-		w := csv.NewWriter(zf)
+		fields = append(fields, key+"LastChangedAt")
+	}
 
-		fields := []string{"id"}
+	if err := w.Write(fields); err != nil {
+		return errors.Wrap(err, "write csv")
+	}
 
-		for _, key := range kind.Keys {
-			fields = append(fields, key, key+"LastChangedAt")
+	err = kind.EachScope(func(scope *Scope) error {
+		fields := []string{scope.ID}
+		if kind.Merged {
+			fields = append(fields, scope.Source)
 		}
 
-		if err := w.Write(fields); err != nil {
-			return errors.Wrap(err, "write csv")
+		for _, key := range kind.Relations {
+			fields = append(fields, cast(scope.relationValue(key)))
 		}
 
-		for _, scope := range kind.Scopes {
-			fields := []string{scope.ID}
+		for _, key := range kind.Keys {
+			size := kind.VectorSize(key)
 
-			for _, key := range kind.Keys {
-				attribute, ok := scope.Attributes[key]
-				if !ok {
-					fields = append(fields, "", "")
+			if size > 0 && vectors == VectorsLong {
+				continue
+			}
 
-					continue
-				}
+			attribute, ok := scope.Attributes[key]
+
+			if size > 0 && vectors == VectorsWide {
+				for i := 0; i < size; i++ {
+					var value string
+					if ok && i < len(attribute.Values) {
+						value = castElement(attribute.Values[i])
+					}
 
-				if attribute.IsVector {
-					b := "[" + strings.Join(attribute.Values, ",") + "]"
+					fields = append(fields, value)
+				}
 
-					fields = append(fields, b)
+				if ok {
+					fields = append(fields, attribute.Last)
 				} else {
-					fields = append(fields, attribute.Value)
+					fields = append(fields, "")
 				}
 
-				fields = append(fields, attribute.Last)
+				continue
 			}
 
-			if err := w.Write(fields); err != nil {
-				return errors.Wrap(err, "write csv")
+			if !ok {
+				fields = append(fields, "", "")
+
+				continue
 			}
+
+			if attribute.IsVector {
+				values := make([]string, len(attribute.Values))
+				for i, v := range attribute.Values {
+					values[i] = cast(v)
+				}
+
+				b := "[" + strings.Join(values, ",") + "]"
+
+				fields = append(fields, b)
+			} else {
+				fields = append(fields, cast(attribute.Value))
+			}
+
+			fields = append(fields, attribute.Last)
 		}
 
-		w.Flush()
+		zf.Rows++
 
-		if err := w.Error(); err != nil {
-			return errors.Wrap(err, "write csv")
+		return errors.Wrap(w.Write(fields), "write csv")
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Flush()
+
+	return errors.Wrap(w.Error(), "write csv")
+}
+
+// writeVectorsCSV writes the elements of the vector attributes of a kind in
+// long format, one row per element.
+func writeVectorsCSV(z *Zip, kind *Kind) error {
+	zf, err := z.Create("vectors/" + camelCase(kind.Name) + ".csv")
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(zf)
+
+	fields := []string{"scopeID", "key", "index", "value", "createdAt"}
+	if kind.Merged {
+		fields = append(fields, "source")
+	}
+
+	if err := w.Write(fields); err != nil {
+		return errors.Wrap(err, "write csv")
+	}
+
+	err = kind.EachScope(func(scope *Scope) error {
+		for _, key := range kind.Keys {
+			attribute, ok := scope.Attributes[key]
+			if !ok || !attribute.IsVector {
+				continue
+			}
+
+			for i, value := range attribute.Values {
+				if value == "" {
+					continue
+				}
+
+				var createdAt string
+				if i < len(attribute.Times) {
+					createdAt = attribute.Times[i]
+				}
+
+				fields := []string{
+					scope.ID,
+					key,
+					strconv.Itoa(i),
+					castElement(value),
+					createdAt,
+				}
+				if kind.Merged {
+					fields = append(fields, scope.Source)
+				}
+
+				zf.Rows++
+
+				if err := w.Write(fields); err != nil {
+					return errors.Wrap(err, "write csv")
+				}
+			}
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	if err := z.Close(); err != nil {
-		return errors.Wrap(err, "close zip")
+	w.Flush()
+
+	return errors.Wrap(w.Error(), "write csv")
+}
+
+// castElement casts the value of a vector element like cast, with objects
+// and arrays as compact JSON.
+func castElement(val string) string {
+	switch decodeValue(val).(type) {
+	case map[string]interface{}, []interface{}:
+		return compactJSON(val)
+	default:
+		return cast(val)
+	}
+}
+
+// writeHistoryCSV writes the history of attribute changes of a kind in long
+// format, one row per change.
+func writeHistoryCSV(z *Zip, kind *Kind) error {
+	zf, err := z.Create("history/" + camelCase(kind.Name) + ".csv")
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(zf)
+
+	fields := []string{"scopeID", "key", "value", "index", "createdAt", "creatorID"}
+	if kind.Merged {
+		fields = append(fields, "source")
+	}
+
+	if err := w.Write(fields); err != nil {
+		return errors.Wrap(err, "write csv")
 	}
 
-	return nil
+	err = kind.EachChange(func(change *Change) error {
+		var index string
+		if change.IsVector {
+			index = strconv.Itoa(change.Index)
+		}
+
+		fields := []string{
+			change.ScopeID,
+			change.Key,
+			cast(change.Value),
+			index,
+			change.CreatedAt,
+			change.CreatorID,
+		}
+		if kind.Merged {
+			fields = append(fields, change.Source)
+		}
+
+		zf.Rows++
+
+		return errors.Wrap(w.Write(fields), "write csv")
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Flush()
+
+	return errors.Wrap(w.Error(), "write csv")
 }
@@ -0,0 +1,163 @@
+package export
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// checkRows checks the columns of the rows set in want, in order.
+func checkRows(t *testing.T, name string, rows, want []map[string]string) {
+	t.Helper()
+
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows in %s, want %d: %v", len(rows), name, len(want), rows)
+	}
+
+	for i, w := range want {
+		for col, val := range w {
+			if rows[i][col] != val {
+				t.Errorf("%s row %d: got %s %q, want %q", name, i, col, rows[i][col], val)
+			}
+		}
+	}
+}
+
+func TestExportHistory(t *testing.T) {
+	tajfile := writeTajfile(t,
+		[][2]string{{"g1", "game"}, {"p1", "player"}},
+		[][3]string{
+			{"g1", "status", `"created"`},
+			{"p1", "chat[0]", `"hi"`},
+			{"g1", "status", `"running"`},
+			{"p1", "chat[1]", `"yo"`},
+			{"p1", "chat[0]", `"hey"`},
+			{"g1", "status", `"ended"`},
+		})
+
+	tests := []struct {
+		name    string
+		history bool
+		file    string
+		want    []map[string]string
+	}{
+		{
+			name:    "every write of the game",
+			history: true,
+			file:    "history/game.csv",
+			want: []map[string]string{
+				{"scopeID": "g1", "key": "status", "value": "created", "index": "", "createdAt": "2023-01-01T00:00:00Z", "creatorID": "admin"},
+				{"scopeID": "g1", "key": "status", "value": "running", "index": "", "createdAt": "2023-01-01T00:00:02Z", "creatorID": "admin"},
+				{"scopeID": "g1", "key": "status", "value": "ended", "index": "", "createdAt": "2023-01-01T00:00:05Z", "creatorID": "admin"},
+			},
+		},
+		{
+			name:    "every write of vector elements",
+			history: true,
+			file:    "history/player.csv",
+			want: []map[string]string{
+				{"scopeID": "p1", "key": "chat", "value": "hi", "index": "0", "createdAt": "2023-01-01T00:00:01Z"},
+				{"scopeID": "p1", "key": "chat", "value": "yo", "index": "1", "createdAt": "2023-01-01T00:00:03Z"},
+				{"scopeID": "p1", "key": "chat", "value": "hey", "index": "0", "createdAt": "2023-01-01T00:00:04Z"},
+			},
+		},
+		{
+			name: "last values only",
+			file: "history/game.csv",
+		},
+		{
+			name:    "last values along history",
+			history: true,
+			file:    "game.csv",
+			want:    []map[string]string{{"id": "g1", "status": "ended", "statusLastChangedAt": "2023-01-01T00:00:05Z"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "export.zip")
+			if err := Export(tajfile, filename, &Options{History: tt.history}); err != nil {
+				t.Fatalf("export: %v", err)
+			}
+
+			checkRows(t, tt.file, readZipRows(t, filename, tt.file), tt.want)
+		})
+	}
+}
+
+func TestExportVectors(t *testing.T) {
+	tajfile := writeTajfile(t,
+		[][2]string{{"p1", "player"}, {"p2", "player"}},
+		[][3]string{
+			{"p1", "chat[0]", `"a,b"`},
+			{"p1", "chat[1]", `{"x":[1, 2]}`},
+			{"p2", "chat[2]", `"c"`},
+			{"p2", "name", `"bob"`},
+		})
+
+	tests := []struct {
+		name    string
+		vectors string
+		file    string
+		want    []map[string]string
+		missing []string
+	}{
+		{
+			name: "joined",
+			file: "player.csv",
+			want: []map[string]string{
+				{"id": "p1", "chat": `[a,b,{"x":[1, 2]}]`},
+				{"id": "p2", "chat": `[,,c]`, "name": "bob"},
+			},
+		},
+		{
+			name:    "long",
+			vectors: VectorsLong,
+			file:    "vectors/player.csv",
+			want: []map[string]string{
+				{"scopeID": "p1", "key": "chat", "index": "0", "value": "a,b", "createdAt": "2023-01-01T00:00:00Z"},
+				{"scopeID": "p1", "key": "chat", "index": "1", "value": `{"x":[1,2]}`, "createdAt": "2023-01-01T00:00:01Z"},
+				{"scopeID": "p2", "key": "chat", "index": "2", "value": "c", "createdAt": "2023-01-01T00:00:02Z"},
+			},
+		},
+		{
+			name:    "long without vector column",
+			vectors: VectorsLong,
+			file:    "player.csv",
+			want:    []map[string]string{{"id": "p1"}, {"id": "p2", "name": "bob"}},
+			missing: []string{"chat", "chatLastChangedAt"},
+		},
+		{
+			name:    "wide",
+			vectors: VectorsWide,
+			file:    "player.csv",
+			want: []map[string]string{
+				{"id": "p1", "chat_0": "a,b", "chat_1": `{"x":[1,2]}`, "chat_2": "", "chatLastChangedAt": "2023-01-01T00:00:01Z"},
+				{"id": "p2", "chat_0": "", "chat_1": "", "chat_2": "c", "name": "bob"},
+			},
+			missing: []string{"chat", "chat_3"},
+		},
+		{
+			name:    "wide without long file",
+			vectors: VectorsWide,
+			file:    "vectors/player.csv",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "export.zip")
+			if err := Export(tajfile, filename, &Options{Vectors: tt.vectors}); err != nil {
+				t.Fatalf("export: %v", err)
+			}
+
+			rows := readZipRows(t, filename, tt.file)
+			checkRows(t, tt.file, rows, tt.want)
+
+			for _, col := range tt.missing {
+				if _, ok := rows[0][col]; ok {
+					t.Errorf("got %s column in %s", col, tt.file)
+				}
+			}
+		})
+	}
+}
@@ -1,181 +1,592 @@
 package export
 
 import (
-	"bufio"
+	"encoding/gob"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"sort"
 	"strings"
+	"time"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 )
 
+// Export formats.
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+	FormatSQLite  = "sqlite"
+)
+
+// Vector expansions, see Options.Vectors.
+const (
+	VectorsJoined = "joined"
+	VectorsLong   = "long"
+	VectorsWide   = "wide"
+)
+
+// Options configures what is exported.
+type Options struct {
+	// Format of the exported files, one of FormatCSV (default), FormatJSONL,
+	// FormatParquet or FormatSQLite.
+	Format string
+
+	// History exports every write of every attribute, in addition to the last
+	// value of attributes.
+	History bool
+
+	// Vectors sets how vector attributes are exported in CSV files:
+	// VectorsJoined (default) in a single column, as [a,b,c]; VectorsLong in
+	// a vectors/<kind> file, one row per element; VectorsWide in one column
+	// per index, key_0 to key_n.
+	Vectors string
+
+	// Relational exports the relations between Scopes (batch, game, round,
+	// stage and player) as foreign key columns, see Kind.Relations.
+	Relational bool
+
+	// Since and Until restrict the export to Scopes created, and attributes
+	// changed, from Since and before Until. Zero times are not used.
+	Since time.Time
+	Until time.Time
+
+	// Kinds restricts the export to the given kinds of Scopes.
+	Kinds []string
+
+	// IncludeKeys and ExcludeKeys are patterns of attribute keys, as in
+	// path.Match, to include in or exclude from the export. All keys are
+	// included if IncludeKeys is empty.
+	IncludeKeys []string
+	ExcludeKeys []string
+
+	// Games restricts the export to the given games, with their rounds,
+	// stages, players, and playerGame, playerRound and playerStage Scopes.
+	Games []string
+
+	// Anonymize rules are applied to attribute values, see LoadRules.
+	Anonymize *Rules
+
+	// Checkpoint is a directory where the records of the tajriba file are
+	// kept between exports, for incremental exports. Only the lines added to
+	// the tajriba file since the previous export are then read, and the
+	// export is regenerated from the kept records. It cannot be used with
+	// Merge, nor with Anonymize, as the records keep the original values.
+	Checkpoint string
+
+	// Merge are other tajriba files exported with the tajriba file, as one
+	// dataset. Scopes and attributes found in several files, such as in
+	// copies of the same tajriba file, are only exported once. Rows are
+	// tagged with the name of the file they come from, see Source.
+	Merge []*Source
+
+	// Source is the name of the tajriba file in merged exports and in the
+	// manifest, the file name by default.
+	Source string
+
+	// Project is the project of the data, listed in the manifest.
+	Project *Project
+}
+
+// Source is a tajriba file merged into an export, see Options.Merge.
+type Source struct {
+	// Name tags the rows of the file in the export, the file name by
+	// default.
+	Name string
+	File string
+}
+
+// Kind is a kind of Scope found in the tajriba file, with all the attribute
+// keys used on Scopes of this kind. Scopes are kept on disk and are read back
+// with EachScope.
 type Kind struct {
-	Name   string
-	Keys   []string
-	keys   map[string]struct{}
-	Scopes map[string]*Scope
+	Name  string
+	Keys  []string
+	Count int
+
+	// Relations are the keys holding the IDs of related Scopes, only set in
+	// relational exports. They are not part of Keys.
+	Relations []string
+
+	// Merged is true if several tajriba files were exported, rows then have
+	// a source column.
+	Merged bool
+
+	keys    map[string]struct{}
+	vectors map[string]int
+	infos   map[string]*KeyInfo
+	scopes  *spill
+	history *spill
 }
 
 type Scope struct {
 	ID         string
 	Attributes map[string]*Attribute
+
+	// Source is the name of the tajriba file the Scope was first found in,
+	// in merged exports.
+	Source string
 }
 
+// Attribute is the last state of an attribute on a Scope. Values are JSON
+// encoded, as stored in the tajriba file. Values of vectors without a value at
+// a given index are empty.
 type Attribute struct {
 	Key      string
 	IsVector bool
 	Value    string
 	Values   []string
 	Last     string
+
+	// Times are the last change times of the Values of vectors.
+	Times []string
+}
+
+// Change is a single write of an attribute on a Scope. Value is JSON encoded.
+type Change struct {
+	ScopeID   string
+	Key       string
+	Value     string
+	IsVector  bool
+	Index     int
+	CreatedAt string
+	CreatorID string
+
+	// Source is the name of the tajriba file of the change, in merged
+	// exports.
+	Source string
+}
+
+// VectorSize returns the largest size of the vector attributes with the key,
+// or 0 if the key is not a vector.
+func (k *Kind) VectorSize(key string) int {
+	return k.vectors[key]
 }
 
-func prepare(tajfile string) ([]*Kind, error) {
-	file, err := os.Open(tajfile)
+// EachScope calls fn with every Scope of the kind.
+func (k *Kind) EachScope(fn func(*Scope) error) error {
+	return k.scopes.each(func(dec *gob.Decoder) error {
+		scope := &Scope{}
+
+		if err := dec.Decode(scope); err != nil {
+			return err
+		}
+
+		if scope.Attributes == nil {
+			scope.Attributes = make(map[string]*Attribute)
+		}
+
+		return fn(scope)
+	})
+}
+
+// HasHistory returns true if changes were recorded for this kind.
+func (k *Kind) HasHistory() bool {
+	return k.history != nil
+}
+
+// EachChange calls fn with every attribute change on Scopes of the kind, by
+// Scope and key, in the order they were written. Changes are only recorded if
+// the History option is set.
+func (k *Kind) EachChange(fn func(*Change) error) error {
+	if k.history == nil {
+		return nil
+	}
+
+	return k.history.each(func(dec *gob.Decoder) error {
+		change := &Change{}
+
+		if err := dec.Decode(change); err != nil {
+			return err
+		}
+
+		return fn(change)
+	})
+}
+
+func (k *Kind) close() error {
+	if err := k.scopes.close(); err != nil {
+		return err
+	}
+
+	if k.history != nil {
+		return k.history.close()
+	}
+
+	return nil
+}
+
+// dataset is the result of preparing a tajriba file for export. It must be
+// closed to remove the temporary files.
+type dataset struct {
+	dir     string
+	opts    *Options
+	filter  *filter
+	sources []*Source
+	Kinds   []*Kind
+
+	// sourceInfos are the size and hash of the data read from the sources.
+	sourceInfos []*SourceInfo
+
+	// last is the creation time of the last record read.
+	last string
+}
+
+func (d *dataset) Close() error {
+	for _, kind := range d.Kinds {
+		_ = kind.close()
+	}
+
+	return errors.Wrap(os.RemoveAll(d.dir), "remove temporary files")
+}
+
+// prepare reads the tajriba file, and the files merged with it, and gathers
+// the last value of every attribute on every Scope, by kind of Scope. Memory
+// use does not depend on the size of the tajriba file: records are sorted on
+// disk by Scope, then Scopes are written one by one to a temporary file per
+// kind.
+func prepare(tajfile string, opts *Options) (*dataset, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if opts.Anonymize != nil && opts.Checkpoint != "" {
+		return nil, errors.New("incremental export cannot anonymize values, the checkpoint keeps the original values")
+	}
+
+	f, err := newFilter(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "filter")
+	}
+
+	sources, err := mergeSources(tajfile, opts)
 	if err != nil {
-		return nil, errors.Wrap(err, "open tajriba file")
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "empirica-export-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temporary directory")
+	}
+
+	d := &dataset{dir: dir, opts: opts, filter: f, sources: sources}
+
+	if opts.Checkpoint != "" {
+		err = d.loadFile(tajfile, d.loadIncremental)
+	} else {
+		err = d.load()
+	}
+
+	if err != nil {
+		_ = d.Close()
+
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// mergeSources returns the tajriba file and the files merged with it, named.
+func mergeSources(tajfile string, opts *Options) ([]*Source, error) {
+	name := opts.Source
+	if name == "" {
+		name = tajfile
+	}
+
+	sources := []*Source{{Name: name, File: tajfile}}
+	names := map[string]bool{name: true}
+
+	for _, src := range opts.Merge {
+		if src == nil || src.File == "" {
+			return nil, errors.New("merged tajriba file without file name")
+		}
+
+		name := src.Name
+		if name == "" {
+			name = src.File
+		}
+
+		if names[name] {
+			return nil, errors.Errorf("duplicate merged tajriba file name: %s", name)
+		}
+
+		names[name] = true
+
+		sources = append(sources, &Source{Name: name, File: src.File})
+	}
+
+	if len(sources) > 1 && opts.Checkpoint != "" {
+		return nil, errors.New("incremental export cannot merge tajriba files")
+	}
+
+	return sources, nil
+}
+
+// merged returns true if several tajriba files are exported.
+func (d *dataset) merged() bool {
+	return len(d.sources) > 1
+}
+
+// loadFile opens the tajriba file and calls fn with it.
+func (d *dataset) loadFile(filename string, fn func(file *os.File) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrap(err, "open tajriba file")
 	}
 	defer file.Close()
 
-	kinds := make(map[string]*Kind)
-	scopeKinds := make(map[string]*Kind)
+	return fn(file)
+}
 
-	scanner := bufio.NewScanner(file)
-	buf := make([]byte, 0, 2048*1024)
-	scanner.Buffer(buf, 2048*1024)
+func (d *dataset) load() error {
+	s := newSorter(d.dir)
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	var seq uint64
 
-		var data map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &data); err != nil {
-			return nil, errors.Wrap(err, "parse tajriba file")
+	for i, src := range d.sources {
+		err := d.loadFile(src.File, func(file *os.File) error {
+			r := newHashReader(file)
+
+			var err error
+
+			seq, err = d.read(r, s, seq, i)
+			d.sourceInfos = append(d.sourceInfos, r.info(src.Name))
+
+			return err
+		})
+		if err != nil {
+			if d.merged() {
+				return errors.Wrap(err, src.Name)
+			}
+
+			return err
 		}
+	}
 
-		switch data["kind"] {
-		case "Scope":
-			obj := data["obj"].(map[string]interface{})
+	return d.reduce(s)
+}
 
-			id := obj["id"].(string)
-			k := obj["kind"].(string)
+// read adds the records of the tajriba file to the sorter, numbered after
+// seq, and returns the number of the last record. source is the index of the
+// file in the merged files.
+func (d *dataset) read(r io.Reader, s *sorter, seq uint64, source int) (uint64, error) {
+	err := eachLine(r, func(line []byte) error {
+		seq++
 
-			kind, ok := kinds[obj["kind"].(string)]
+		e, err := parseLine(line)
+		if err != nil {
+			return err
+		}
 
-			if ok {
-				if _, ok := kind.Scopes[id]; ok {
-					return nil, errors.New("scope already exists")
-				}
+		if e == nil {
+			return nil
+		}
 
-				kind.Scopes[id] = &Scope{
-					ID:         id,
-					Attributes: make(map[string]*Attribute),
+		e.Seq = seq
+		e.Source = source
+		d.last = e.CreatedAt
+
+		if isLink(e) {
+			if d.opts.Relational {
+				for _, l := range linkEntries(e) {
+					if err := s.add(l); err != nil {
+						return err
+					}
 				}
-			} else {
-				kinds[obj["kind"].(string)] = &Kind{
-					Name: k,
-					Keys: make([]string, 0),
-					keys: make(map[string]struct{}),
-					Scopes: map[string]*Scope{
-						id: {
-							ID:         id,
-							Attributes: make(map[string]*Attribute),
-						},
-					},
+			}
+
+			// Players are selected by the games they played in.
+			if _, ok := linkedGame(e); ok && d.filter.games != nil {
+				return s.add(e)
+			}
+
+			return nil
+		}
+
+		return s.add(e)
+	})
+	if err != nil {
+		return seq, errors.Wrap(err, "read tajriba file")
+	}
+
+	return seq, nil
+}
+
+// reduce gathers the sorted records into Scopes, by kind.
+func (d *dataset) reduce(s *sorter) error {
+	if d.filter.games != nil {
+		if err := s.each(d.filter.collectGames()); err != nil {
+			return err
+		}
+	}
+
+	kinds := make(map[string]*Kind)
+
+	var (
+		scope       *Scope
+		kind        *Kind
+		scopeSeq    uint64
+		scopeSource int
+		skip        bool
+
+		// seen are the IDs of the attributes of the Scope, to skip the
+		// attributes found in several merged files.
+		seen map[string]bool
+	)
+
+	flush := func() error {
+		if scope == nil || skip {
+			return nil
+		}
+
+		kind.Count++
+
+		for key := range scope.Attributes {
+			kind.keyInfo(key).Scopes++
+		}
+
+		return kind.scopes.write(scope)
+	}
+
+	err := s.each(func(e *entry) error {
+		if e.IsScope {
+			if scope != nil && scope.ID == e.ScopeID {
+				if e.Source != scopeSource {
+					return nil
 				}
+
+				return errors.New("scope already exists")
 			}
 
-			scopeKinds[obj["id"].(string)] = kinds[obj["kind"].(string)]
-		case "Attribute":
-			obj, ok := data["obj"].(map[string]interface{})
-			if !ok {
-				continue
+			if err := flush(); err != nil {
+				return err
 			}
 
-			key, ok := obj["key"].(string)
-			if !ok {
-				continue
+			scopeSeq = e.Seq
+			scopeSource = e.Source
+			scope = &Scope{
+				ID:         e.ScopeID,
+				Attributes: make(map[string]*Attribute),
+				Source:     d.sourceName(e.Source),
 			}
 
-			valRaw, ok := obj["val"].(string)
-			if !ok {
-				valRaw = "null"
+			if d.merged() {
+				seen = make(map[string]bool)
 			}
 
-			val := cast(valRaw)
+			skip = !d.filter.keepScope(e.Kind, e.ScopeID, e.CreatedAt)
+			if skip {
+				return nil
+			}
 
-			createdAt, ok := obj["createdAt"].(string)
+			k, ok := kinds[e.Kind]
 			if !ok {
-				continue
+				var err error
+
+				k, err = d.newKind(e.Kind)
+				if err != nil {
+					return err
+				}
+
+				kinds[e.Kind] = k
 			}
 
-			vector, _ := obj["vector"].(bool)
-			indexf, hasIndex := obj["index"].(float64)
-			index := int(indexf)
+			kind = k
 
-			if vector && !hasIndex {
-				spew.Dump(data)
+			return nil
+		}
 
-				return nil, errors.New("vector without index")
+		// Link entries are numbered after the key set on the player, which
+		// can be read before the linked Scope, e.g. from a server.
+		if scope == nil || scope.ID != e.ScopeID || (e.Seq < scopeSeq && !e.Link) {
+			if e.Link {
+				return nil
 			}
 
-			if strings.HasPrefix(key, "ran-on-") ||
-				strings.HasPrefix(key, "ran-before-") ||
-				strings.HasPrefix(key, "ran-after-") ||
-				strings.HasPrefix(key, "playerGameID-") ||
-				strings.HasPrefix(key, "playerRoundID-") ||
-				strings.HasPrefix(key, "playerStageID-") {
-				continue
+			return errors.New("scope not found")
+		}
+
+		if skip || (!e.Link && isLink(e)) {
+			return nil
+		}
+
+		if _, ok := scope.Attributes[e.Key]; ok && e.Link {
+			return nil
+		}
+
+		if seen != nil && e.ID != "" {
+			if seen[e.ID] {
+				return nil
 			}
 
-			nodeID, ok := obj["nodeID"].(string)
-			if !ok {
-				return nil, errors.New("nodeID not found")
+			seen[e.ID] = true
+		}
+
+		if !d.filter.keepAttribute(kind.Name, e.Key, e.CreatedAt) {
+			return nil
+		}
+
+		val, ok := d.opts.Anonymize.apply(kind.Name, e.Key, e.Val)
+		if !ok {
+			return nil
+		}
+
+		kind.keys[e.Key] = struct{}{}
+
+		attr, ok := scope.Attributes[e.Key]
+		if !ok {
+			attr = &Attribute{
+				Key:      e.Key,
+				IsVector: e.Vector,
 			}
 
-			kind, ok := scopeKinds[nodeID]
-			if !ok {
-				return nil, errors.New("scope not found")
+			scope.Attributes[e.Key] = attr
+		}
+
+		if attr.IsVector {
+			if e.Index+1 > len(attr.Values) {
+				attr.Values = append(attr.Values, make([]string, e.Index+1-len(attr.Values))...)
+				attr.Times = append(attr.Times, make([]string, e.Index+1-len(attr.Times))...)
 			}
 
-			kind.keys[key] = struct{}{}
+			attr.Values[e.Index] = val
+			attr.Times[e.Index] = e.CreatedAt
 
-			attr, ok := kind.Scopes[nodeID].Attributes[key]
-			if ok {
-				if attr.IsVector {
-					if index+1 > len(attr.Values) {
-						attr.Values = append(attr.Values, make([]string, index+1-len(attr.Values))...)
-					}
+			if len(attr.Values) > kind.vectors[e.Key] {
+				kind.vectors[e.Key] = len(attr.Values)
+			}
+		} else {
+			attr.Value = val
+		}
 
-					attr.Values[index] = val
-				} else {
-					attr.Value = val
-				}
-				attr.Last = createdAt
-			} else {
-				attr = &Attribute{
-					Key:      key,
-					IsVector: vector,
-					Last:     createdAt,
-				}
+		attr.Last = e.CreatedAt
 
-				if attr.IsVector {
-					attr.Values = []string{val}
-				} else {
-					attr.Value = val
-				}
+		kind.keyInfo(e.Key).observe(e.Vector, val, e.CreatedAt)
 
-				kind.Scopes[nodeID].Attributes[key] = attr
-			}
-		default:
-			// fmt.Printf("Else: %+v\n", data)
-			continue
+		if kind.history == nil || e.Link {
+			return nil
 		}
+
+		return kind.history.write(&Change{
+			ScopeID:   e.ScopeID,
+			Key:       e.Key,
+			Value:     val,
+			IsVector:  e.Vector,
+			Index:     e.Index,
+			CreatedAt: e.CreatedAt,
+			CreatorID: e.CreatorID,
+			Source:    d.sourceName(e.Source),
+		})
+	})
+	if err != nil {
+		return err
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, errors.Wrap(err, "read tajriba file")
+	if err := flush(); err != nil {
+		return err
 	}
 
 	kindNames := make([]string, 0, len(kinds))
@@ -186,11 +597,15 @@ func prepare(tajfile string) ([]*Kind, error) {
 
 	sort.StringSlice(kindNames).Sort()
 
-	k := make([]*Kind, 0, len(kindNames))
+	d.Kinds = make([]*Kind, 0, len(kindNames))
 
 	for _, kindName := range kindNames {
 		kind := kinds[kindName]
 
+		if err := kind.close(); err != nil {
+			return err
+		}
+
 		kind.Keys = make([]string, 0, len(kind.keys))
 
 		for key := range kind.keys {
@@ -201,18 +616,164 @@ func prepare(tajfile string) ([]*Kind, error) {
 
 		sort.StringSlice(kind.Keys).Sort()
 
-		k = append(k, kind)
+		if d.opts.Relational {
+			kind.setRelations()
+		}
+
+		d.Kinds = append(d.Kinds, kind)
+	}
+
+	return nil
+}
+
+// sourceName returns the name of the merged file at index i, or an empty
+// string if files are not merged.
+func (d *dataset) sourceName(i int) string {
+	if !d.merged() {
+		return ""
+	}
+
+	return d.sources[i].Name
+}
+
+func (d *dataset) newKind(name string) (*Kind, error) {
+	scopes, err := newSpill(d.dir, "scopes-*")
+	if err != nil {
+		return nil, err
+	}
+
+	kind := &Kind{
+		Name:    name,
+		Merged:  d.merged(),
+		keys:    make(map[string]struct{}),
+		vectors: make(map[string]int),
+		infos:   make(map[string]*KeyInfo),
+		scopes:  scopes,
+	}
+
+	// Keep track of the kind right away so its files are closed on error.
+	d.Kinds = append(d.Kinds, kind)
+
+	if d.opts.History {
+		kind.history, err = newSpill(d.dir, "history-*")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return kind, nil
+}
+
+type record struct {
+	Kind string          `json:"kind"`
+	Obj  json.RawMessage `json:"obj"`
+}
+
+type scopeRecord struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type attributeRecord struct {
+	ID        string      `json:"id"`
+	Key       interface{} `json:"key"`
+	Val       interface{} `json:"val"`
+	CreatedAt interface{} `json:"createdAt"`
+	Vector    bool        `json:"vector"`
+	Index     *float64    `json:"index"`
+	NodeID    interface{} `json:"nodeID"`
+	CreatorID string      `json:"creatorID"`
+}
+
+// parseLine parses a line of the tajriba file. It returns nil if the line is
+// not relevant to the export.
+func parseLine(line []byte) (*entry, error) {
+	var rec record
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return nil, errors.Wrap(err, "parse tajriba file")
 	}
 
-	return k, nil
+	switch rec.Kind {
+	case "Scope":
+		var obj scopeRecord
+		if err := json.Unmarshal(rec.Obj, &obj); err != nil {
+			return nil, errors.Wrap(err, "parse scope")
+		}
+
+		return &entry{
+			ScopeID:   obj.ID,
+			IsScope:   true,
+			Kind:      obj.Kind,
+			CreatedAt: obj.CreatedAt,
+		}, nil
+	case "Attribute":
+		var obj attributeRecord
+		if err := json.Unmarshal(rec.Obj, &obj); err != nil {
+			return nil, errors.Wrap(err, "parse attribute")
+		}
+
+		key, ok := obj.Key.(string)
+		if !ok {
+			return nil, nil
+		}
+
+		val, ok := obj.Val.(string)
+		if !ok {
+			val = "null"
+		}
+
+		createdAt, ok := obj.CreatedAt.(string)
+		if !ok {
+			return nil, nil
+		}
+
+		if obj.Vector && obj.Index == nil {
+			return nil, errors.Errorf("attribute %s of %s: vector without index", obj.ID, key)
+		}
+
+		var index int
+		if obj.Index != nil {
+			index = int(*obj.Index)
+		}
+
+		if strings.HasPrefix(key, "ran-on-") ||
+			strings.HasPrefix(key, "ran-before-") ||
+			strings.HasPrefix(key, "ran-after-") {
+			return nil, nil
+		}
+
+		nodeID, ok := obj.NodeID.(string)
+		if !ok {
+			return nil, errors.New("nodeID not found")
+		}
+
+		return &entry{
+			ID:        obj.ID,
+			ScopeID:   nodeID,
+			Key:       key,
+			Val:       val,
+			Vector:    obj.Vector,
+			Index:     index,
+			CreatedAt: createdAt,
+			CreatorID: obj.CreatorID,
+		}, nil
+	default:
+		return nil, nil
+	}
 }
 
 func cast(val string) string {
+	if val == "" {
+		return val
+	}
+
 	var v interface{}
 
 	err := json.Unmarshal([]byte(val), &v)
 	if err != nil {
-		fmt.Printf("err: %s, val: %+v\n", err.Error(), val)
+		log.Warn().Err(err).Str("value", val).Msg("export: invalid attribute value, exported as is")
+
 		return val
 	}
 
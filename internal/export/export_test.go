@@ -0,0 +1,190 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tajLines returns the lines of a tajriba file with the scopes, by ID and
+// kind, and the attributes, as scope ID, key and JSON value. Keys as key[i]
+// set the element i of the vector key. The ith attribute is created i seconds
+// after the scopes, by the admin.
+func tajLines(scopes [][2]string, attributes [][3]string) []string {
+	var lines []string
+
+	for _, s := range scopes {
+		lines = append(lines, fmt.Sprintf(`{"kind":"Scope","obj":{"id":%q,"kind":%q,"createdAt":"2023-01-01T00:00:00Z"}}`, s[0], s[1]))
+	}
+
+	for i, a := range attributes {
+		key, vector := a[1], ""
+		if j := strings.Index(key, "["); j > 0 {
+			key, vector = key[:j], fmt.Sprintf(`,"vector":true,"index":%s`, strings.Trim(key[j:], "[]"))
+		}
+
+		lines = append(lines, fmt.Sprintf(`{"kind":"Attribute","obj":{"id":"a%d","key":%q,"val":%q%s,"createdAt":"2023-01-01T00:%02d:%02dZ","nodeID":%q,"creatorID":"admin"}}`,
+			i, key, a[2], vector, i/60, i%60, a[0]))
+	}
+
+	return lines
+}
+
+// writeTajfile writes a tajriba file with the scopes and attributes, see
+// tajLines.
+func writeTajfile(t *testing.T, scopes [][2]string, attributes [][3]string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "tajriba.json")
+
+	content := strings.Join(tajLines(scopes, attributes), "\n") + "\n"
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+// readZipRows returns the rows of a CSV file of the zip file, by column name,
+// or nil if the zip file has no such file.
+func readZipRows(t *testing.T, filename, name string) []map[string]string {
+	t.Helper()
+
+	z, err := zip.OpenReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	f, err := z.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+
+	rows := make([]map[string]string, 0, len(records))
+
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(record))
+		for i, col := range records[0] {
+			row[col] = record[i]
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+func TestExportMerge(t *testing.T) {
+	scopes := [][2]string{{"g1", "game"}, {"p1", "player"}}
+	attributes := [][3]string{
+		{"g1", "status", `"running"`},
+		{"p1", "score", `1`},
+	}
+
+	pilot := writeTajfile(t, scopes, attributes)
+
+	// The main file is a copy of the pilot file, which went on.
+	main := writeTajfile(t,
+		append(scopes, [2]string{"g2", "game"}),
+		append(attributes,
+			[3]string{"g1", "status", `"ended"`},
+			[3]string{"g2", "status", `"running"`},
+		))
+
+	tests := []struct {
+		name string
+		file string
+		want []map[string]string
+	}{
+		{
+			name: "scopes tagged with their first source",
+			file: "game.csv",
+			want: []map[string]string{
+				{"id": "g1", "source": "pilot", "status": "ended"},
+				{"id": "g2", "source": "main", "status": "running"},
+			},
+		},
+		{
+			name: "attributes in several sources once",
+			file: "history/game.csv",
+			want: []map[string]string{
+				{"scopeID": "g1", "value": "running", "source": "pilot"},
+				{"scopeID": "g1", "value": "ended", "source": "main"},
+				{"scopeID": "g2", "value": "running", "source": "main"},
+			},
+		},
+		{
+			name: "scopes in every source once",
+			file: "player.csv",
+			want: []map[string]string{{"id": "p1", "source": "pilot", "score": "1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "export.zip")
+
+			opts := &Options{
+				Source:  "pilot",
+				Merge:   []*Source{{Name: "main", File: main}},
+				History: true,
+			}
+			if err := Export(pilot, filename, opts); err != nil {
+				t.Fatalf("export: %v", err)
+			}
+
+			checkRows(t, tt.file, readZipRows(t, filename, tt.file), tt.want)
+		})
+	}
+}
+
+func TestExportMergeErrors(t *testing.T) {
+	tajfile := writeTajfile(t, [][2]string{{"g1", "game"}}, nil)
+
+	tests := []struct {
+		name string
+		opts *Options
+		want string
+	}{
+		{
+			name: "duplicate names",
+			opts: &Options{Source: "a", Merge: []*Source{{Name: "a", File: tajfile}}},
+			want: "duplicate merged tajriba file name: a",
+		},
+		{
+			name: "duplicate files",
+			opts: &Options{Merge: []*Source{{File: tajfile}}},
+			want: "duplicate merged tajriba file name: " + tajfile,
+		},
+		{
+			name: "missing file name",
+			opts: &Options{Merge: []*Source{{Name: "a"}}},
+			want: "merged tajriba file without file name",
+		},
+		{
+			name: "missing file",
+			opts: &Options{Merge: []*Source{{File: filepath.Join(t.TempDir(), "missing.json")}}},
+			want: "open tajriba file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Export(tajfile, filepath.Join(t.TempDir(), "export.zip"), tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package export
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// gameKinds are the kinds of Scopes that belong to a game, selected with their
+// game by Options.Games.
+var gameKinds = map[string]bool{
+	"game":        true,
+	"round":       true,
+	"stage":       true,
+	"player":      true,
+	"playerGame":  true,
+	"playerRound": true,
+	"playerStage": true,
+}
+
+// filter selects the Scopes and attributes to export from the Options.
+type filter struct {
+	since      time.Time
+	until      time.Time
+	kinds      map[string]bool
+	include    []string
+	exclude    []string
+	games      map[string]bool
+	relational bool
+
+	// scopes are the IDs of the Scopes belonging to the selected games, see
+	// collectGames.
+	scopes map[string]bool
+}
+
+func newFilter(opts *Options) (*filter, error) {
+	f := &filter{
+		since:      opts.Since,
+		until:      opts.Until,
+		include:    opts.IncludeKeys,
+		exclude:    opts.ExcludeKeys,
+		relational: opts.Relational,
+	}
+
+	if !f.since.IsZero() && !f.until.IsZero() && f.until.Before(f.since) {
+		return nil, errors.New("until is before since")
+	}
+
+	for _, pattern := range append(append([]string{}, f.include...), f.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid key pattern %q", pattern)
+		}
+	}
+
+	if len(opts.Kinds) > 0 {
+		f.kinds = make(map[string]bool, len(opts.Kinds))
+
+		for _, kind := range opts.Kinds {
+			f.kinds[camelCase(strings.TrimSpace(kind))] = true
+		}
+	}
+
+	if len(opts.Games) > 0 {
+		f.games = make(map[string]bool, len(opts.Games))
+		f.scopes = make(map[string]bool)
+
+		for _, id := range opts.Games {
+			f.games[strings.TrimSpace(id)] = true
+		}
+	}
+
+	return f, nil
+}
+
+// collectGames returns a function to call with every entry, in sorted order,
+// which collects the IDs of the Scopes belonging to the selected games: the
+// games themselves and the Scopes with a gameID attribute (or a playerGameID-
+// link key for players) set to one of the games.
+func (f *filter) collectGames() func(e *entry) error {
+	var scopeID, kind string
+
+	return func(e *entry) error {
+		if e.ScopeID != scopeID {
+			scopeID = e.ScopeID
+			kind = ""
+		}
+
+		if e.IsScope {
+			kind = camelCase(e.Kind)
+
+			if kind == "game" && f.games[e.ScopeID] {
+				f.scopes[e.ScopeID] = true
+			}
+
+			return nil
+		}
+
+		if !gameKinds[kind] || e.Vector {
+			return nil
+		}
+
+		gameID, ok := linkedGame(e)
+		if !ok && e.Key == "gameID" {
+			_ = json.Unmarshal([]byte(e.Val), &gameID)
+		}
+
+		if gameID != "" && f.games[gameID] {
+			f.scopes[e.ScopeID] = true
+		}
+
+		return nil
+	}
+}
+
+// keepScope returns true if the Scope of the given kind, ID and creation time
+// is selected.
+func (f *filter) keepScope(kind, id, createdAt string) bool {
+	if f.kinds != nil && !f.kinds[camelCase(kind)] {
+		return false
+	}
+
+	if f.games != nil && !f.scopes[id] {
+		return false
+	}
+
+	return f.inWindow(createdAt)
+}
+
+// keepAttribute returns true if the change of the attribute key, on a Scope
+// of the given kind, is selected. Foreign keys of relational exports are
+// always kept.
+func (f *filter) keepAttribute(kind, key, createdAt string) bool {
+	if !f.inWindow(createdAt) {
+		return false
+	}
+
+	if f.relational && isRelationKey(kind, key) {
+		return true
+	}
+
+	if len(f.include) > 0 && !matchAny(f.include, key) {
+		return false
+	}
+
+	return !matchAny(f.exclude, key)
+}
+
+// inWindow returns true if createdAt is within the since and until times.
+// Without a time window, invalid times are accepted.
+func (f *filter) inWindow(createdAt string) bool {
+	if f.since.IsZero() && f.until.IsZero() {
+		return true
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return false
+	}
+
+	if !f.since.IsZero() && t.Before(f.since) {
+		return false
+	}
+
+	return f.until.IsZero() || t.Before(f.until)
+}
+
+func matchAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,168 @@
+package export
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportFilter(t *testing.T) {
+	tajfile := writeTajfile(t,
+		[][2]string{
+			{"g1", "game"}, {"g2", "game"}, {"r1", "round"}, {"r2", "round"},
+			{"p1", "player"}, {"p2", "player"}, {"pg1", "playerGame"},
+		},
+		[][3]string{
+			{"g1", "status", `"ended"`},
+			{"g2", "status", `"ended"`},
+			{"r1", "gameID", `"g1"`},
+			{"r2", "gameID", `"g2"`},
+			{"p1", "playerGameID-g1", `"pg1"`},
+			{"p2", "playerGameID-g2", `"pg2"`},
+			{"pg1", "gameID", `"g1"`},
+			{"p1", "participantIdentifier", `"ann"`},
+			{"p1", "email", `"ann@example.com"`},
+			{"p2", "participantIdentifier", `"bob"`},
+		})
+
+	at := func(sec int) time.Time {
+		return time.Date(2023, 1, 1, 0, 0, sec, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name    string
+		opts    *Options
+		file    string
+		want    []map[string]string
+		missing []string
+	}{
+		{
+			name: "kinds",
+			opts: &Options{Kinds: []string{"game", " player"}},
+			file: "player.csv",
+			want: []map[string]string{{"id": "p1"}, {"id": "p2"}},
+		},
+		{
+			name: "kinds without the kind",
+			opts: &Options{Kinds: []string{"game", "player"}},
+			file: "round.csv",
+		},
+		{
+			name:    "included keys",
+			opts:    &Options{IncludeKeys: []string{"participant*"}},
+			file:    "player.csv",
+			want:    []map[string]string{{"id": "p1", "participantIdentifier": "ann"}, {"id": "p2", "participantIdentifier": "bob"}},
+			missing: []string{"email"},
+		},
+		{
+			name:    "excluded keys",
+			opts:    &Options{ExcludeKeys: []string{"email", "status"}},
+			file:    "player.csv",
+			want:    []map[string]string{{"id": "p1", "participantIdentifier": "ann"}, {"id": "p2", "participantIdentifier": "bob"}},
+			missing: []string{"email"},
+		},
+		{
+			name: "excluded keys of other kinds",
+			opts: &Options{ExcludeKeys: []string{"email", "status"}},
+			file: "round.csv",
+			want: []map[string]string{{"id": "r1", "gameID": "g1"}, {"id": "r2", "gameID": "g2"}},
+		},
+		{
+			name: "games",
+			opts: &Options{Games: []string{"g1"}},
+			file: "game.csv",
+			want: []map[string]string{{"id": "g1", "status": "ended"}},
+		},
+		{
+			name: "rounds of games",
+			opts: &Options{Games: []string{"g1"}},
+			file: "round.csv",
+			want: []map[string]string{{"id": "r1", "gameID": "g1"}},
+		},
+		{
+			name:    "players of games",
+			opts:    &Options{Games: []string{"g1"}},
+			file:    "player.csv",
+			want:    []map[string]string{{"id": "p1", "participantIdentifier": "ann"}},
+			missing: []string{"playerGameID-g1"},
+		},
+		{
+			name: "playerGames of games",
+			opts: &Options{Games: []string{"g1"}},
+			file: "playerGame.csv",
+			want: []map[string]string{{"id": "pg1", "gameID": "g1"}},
+		},
+		{
+			name:    "until",
+			opts:    &Options{Until: at(2)},
+			file:    "round.csv",
+			want:    []map[string]string{{"id": "r1"}, {"id": "r2"}},
+			missing: []string{"gameID"},
+		},
+		{
+			name: "since and until",
+			opts: &Options{Since: at(0), Until: at(3)},
+			file: "round.csv",
+			want: []map[string]string{{"id": "r1", "gameID": "g1"}, {"id": "r2", "gameID": ""}},
+		},
+		{
+			name: "since after the scopes",
+			opts: &Options{Since: at(1)},
+			file: "game.csv",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "export.zip")
+			if err := Export(tajfile, filename, tt.opts); err != nil {
+				t.Fatalf("export: %v", err)
+			}
+
+			rows := readZipRows(t, filename, tt.file)
+			checkRows(t, tt.file, rows, tt.want)
+
+			for _, col := range tt.missing {
+				if _, ok := rows[0][col]; ok {
+					t.Errorf("got %s column in %s", col, tt.file)
+				}
+			}
+		})
+	}
+}
+
+func TestExportFilterErrors(t *testing.T) {
+	tajfile := writeTajfile(t, [][2]string{{"g1", "game"}}, nil)
+
+	tests := []struct {
+		name string
+		opts *Options
+		want string
+	}{
+		{
+			name: "until before since",
+			opts: &Options{Since: time.Now(), Until: time.Now().Add(-time.Hour)},
+			want: "until is before since",
+		},
+		{
+			name: "invalid include pattern",
+			opts: &Options{IncludeKeys: []string{"[a"}},
+			want: `invalid key pattern "[a"`,
+		},
+		{
+			name: "invalid exclude pattern",
+			opts: &Options{ExcludeKeys: []string{"a\\"}},
+			want: `invalid key pattern "a\\"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Export(tajfile, filepath.Join(t.TempDir(), "export.zip"), tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
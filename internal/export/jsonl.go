@@ -0,0 +1,192 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+var jsonlFormat = &Format{
+	Name:      FormatJSONL,
+	Ext:       "zip",
+	NewWriter: newJSONLWriter,
+}
+
+// ExportJSONL exports the data of the tajriba file to a zip file containing a
+// JSON Lines file per kind of Scope. Values keep their JSON type.
+func ExportJSONL(tajfile, filename string, opts *Options) error {
+	return exportFormat(tajfile, filename, opts, jsonlFormat)
+}
+
+func newJSONLWriter(filename string, _ *Options) (Writer, error) {
+	return NewZipWriter(filename, func(z *Zip, kind *Kind) error {
+		if err := writeKindJSONL(z, kind); err != nil {
+			return err
+		}
+
+		if kind.HasHistory() {
+			return writeHistoryJSONL(z, kind)
+		}
+
+		return nil
+	})
+}
+
+func writeKindJSONL(z *Zip, kind *Kind) error {
+	zf, err := z.Create(camelCase(kind.Name) + ".jsonl")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(zf)
+
+	var buf bytes.Buffer
+
+	err = kind.EachScope(func(scope *Scope) error {
+		buf.Reset()
+		buf.WriteString(`{"id":`)
+		writeJSONString(&buf, scope.ID)
+
+		if kind.Merged {
+			buf.WriteString(`,"source":`)
+			writeJSONString(&buf, scope.Source)
+		}
+
+		for _, key := range kind.Relations {
+			buf.WriteByte(',')
+			writeJSONString(&buf, key)
+			buf.WriteByte(':')
+			writeJSONRaw(&buf, scope.relationValue(key))
+		}
+
+		for _, key := range kind.Keys {
+			buf.WriteByte(',')
+			writeJSONString(&buf, key)
+			buf.WriteByte(':')
+
+			attribute, ok := scope.Attributes[key]
+			if !ok {
+				buf.WriteString("null,")
+				writeJSONString(&buf, key+"LastChangedAt")
+				buf.WriteString(":null")
+
+				continue
+			}
+
+			writeJSONValue(&buf, attribute)
+			buf.WriteByte(',')
+			writeJSONString(&buf, key+"LastChangedAt")
+			buf.WriteByte(':')
+			writeJSONString(&buf, attribute.Last)
+		}
+
+		buf.WriteString("}\n")
+
+		zf.Rows++
+
+		_, err := buf.WriteTo(w)
+
+		return errors.Wrap(err, "write jsonl")
+	})
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(w.Flush(), "write jsonl")
+}
+
+func writeHistoryJSONL(z *Zip, kind *Kind) error {
+	zf, err := z.Create("history/" + camelCase(kind.Name) + ".jsonl")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(zf)
+
+	var buf bytes.Buffer
+
+	err = kind.EachChange(func(change *Change) error {
+		buf.Reset()
+		buf.WriteString(`{"scopeID":`)
+		writeJSONString(&buf, change.ScopeID)
+		buf.WriteString(`,"key":`)
+		writeJSONString(&buf, change.Key)
+		buf.WriteString(`,"value":`)
+		writeJSONRaw(&buf, change.Value)
+		buf.WriteString(`,"index":`)
+
+		if change.IsVector {
+			buf.WriteString(strconv.Itoa(change.Index))
+		} else {
+			buf.WriteString("null")
+		}
+
+		buf.WriteString(`,"createdAt":`)
+		writeJSONString(&buf, change.CreatedAt)
+		buf.WriteString(`,"creatorID":`)
+		writeJSONString(&buf, change.CreatorID)
+
+		if kind.Merged {
+			buf.WriteString(`,"source":`)
+			writeJSONString(&buf, change.Source)
+		}
+
+		buf.WriteString("}\n")
+
+		zf.Rows++
+
+		_, err := buf.WriteTo(w)
+
+		return errors.Wrap(err, "write jsonl")
+	})
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(w.Flush(), "write jsonl")
+}
+
+// writeJSONValue writes the value of the attribute, as an array for vectors.
+func writeJSONValue(w *bytes.Buffer, attribute *Attribute) {
+	if !attribute.IsVector {
+		writeJSONRaw(w, attribute.Value)
+
+		return
+	}
+
+	w.WriteByte('[')
+
+	for i, v := range attribute.Values {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+
+		writeJSONRaw(w, v)
+	}
+
+	w.WriteByte(']')
+}
+
+// writeJSONRaw writes an already JSON encoded value on a single line. Empty
+// values are written as null and invalid values as strings, so the output is
+// always valid JSON.
+func writeJSONRaw(w *bytes.Buffer, val string) {
+	if val == "" {
+		w.WriteString("null")
+
+		return
+	}
+
+	if err := json.Compact(w, []byte(val)); err != nil {
+		writeJSONString(w, val)
+	}
+}
+
+func writeJSONString(w io.Writer, s string) {
+	b, _ := json.Marshal(s)
+	_, _ = w.Write(b)
+}
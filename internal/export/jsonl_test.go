@@ -0,0 +1,104 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readZipFile returns the content of a file of the zip file.
+func readZipFile(t *testing.T, filename, name string) []byte {
+	t.Helper()
+
+	z, err := zip.OpenReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	f, err := z.Open(name)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+
+	return b
+}
+
+// typedTajfile writes a tajriba file with a value of every JSON type, and a
+// vector, on a game.
+func typedTajfile(t *testing.T) string {
+	t.Helper()
+
+	return writeTajfile(t,
+		[][2]string{{"g1", "game"}},
+		[][3]string{
+			{"g1", "count", `3`},
+			{"g1", "ratio", `0.5`},
+			{"g1", "done", `true`},
+			{"g1", "name", `"a,b"`},
+			{"g1", "config", `{"x":1,"y":["z"]}`},
+			{"g1", "empty", `null`},
+			{"g1", "chat[0]", `"hi"`},
+			{"g1", "chat[1]", `"yo"`},
+		})
+}
+
+func TestExportJSONL(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "export.zip")
+	if err := Export(typedTajfile(t), filename, &Options{Format: FormatJSONL, History: true}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	var rows []map[string]interface{}
+
+	s := bufio.NewScanner(bytes.NewReader(readZipFile(t, filename, "game.jsonl")))
+	for s.Scan() {
+		row := make(map[string]interface{})
+		if err := json.Unmarshal(s.Bytes(), &row); err != nil {
+			t.Fatalf("line %d: %v", len(rows)+1, err)
+		}
+
+		rows = append(rows, row)
+	}
+
+	if len(rows) != 1 {
+		t.Fatalf("got %d rows, want 1", len(rows))
+	}
+
+	tests := []struct {
+		key  string
+		want interface{}
+	}{
+		{"id", "g1"},
+		{"count", 3.0},
+		{"ratio", 0.5},
+		{"done", true},
+		{"name", "a,b"},
+		{"config", map[string]interface{}{"x": 1.0, "y": []interface{}{"z"}}},
+		{"empty", nil},
+		{"chat", []interface{}{"hi", "yo"}},
+		{"countLastChangedAt", "2023-01-01T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		if got := rows[0][tt.key]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("got %s %#v, want %#v", tt.key, got, tt.want)
+		}
+	}
+
+	history := bytes.Count(readZipFile(t, filename, "history/game.jsonl"), []byte("\n"))
+	if history != 8 {
+		t.Errorf("got %d history lines, want 8", history)
+	}
+}
@@ -0,0 +1,174 @@
+package export
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/empiricaly/empirica/internal/build"
+	"github.com/pkg/errors"
+)
+
+// manifestFile is the name of the manifest in zip exports.
+const manifestFile = "manifest.json"
+
+// Manifest describes an export, so exported datasets can be verified and
+// reproduced: the version of empirica, the project, the tajriba files and the
+// options of the export, and the files exported.
+type Manifest struct {
+	CreatedAt string       `json:"createdAt"`
+	Build     *build.Build `json:"build"`
+	Project   *Project     `json:"project,omitempty"`
+	Format    string       `json:"format"`
+
+	Sources []*SourceInfo    `json:"sources"`
+	Options *ManifestOptions `json:"options"`
+
+	// Files are set by the Writer of the export, see ManifestWriter.
+	Files []*FileInfo `json:"files"`
+}
+
+// Project identifies the project of the exported data, from its
+// configuration and its .empirica/id file.
+type Project struct {
+	Name string `json:"name,omitempty"`
+	ID   string `json:"id,omitempty"`
+}
+
+// SourceInfo identifies a tajriba file exported, by the size and SHA-256 of
+// the data read.
+type SourceInfo struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// FileInfo describes an exported file. Rows is the number of rows, or
+// records, of the file.
+type FileInfo struct {
+	Name   string `json:"name"`
+	Rows   int    `json:"rows"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// ManifestOptions are the options of an export, as listed in the manifest.
+// The salt of anonymization rules is not listed.
+type ManifestOptions struct {
+	History     bool     `json:"history"`
+	Vectors     string   `json:"vectors,omitempty"`
+	Relational  bool     `json:"relational"`
+	Since       string   `json:"since,omitempty"`
+	Until       string   `json:"until,omitempty"`
+	Kinds       []string `json:"kinds,omitempty"`
+	IncludeKeys []string `json:"includeKeys,omitempty"`
+	ExcludeKeys []string `json:"excludeKeys,omitempty"`
+	Games       []string `json:"games,omitempty"`
+	Anonymize   []*Rule  `json:"anonymize,omitempty"`
+	Incremental bool     `json:"incremental"`
+}
+
+// ManifestWriter is implemented by Writers recording the manifest of their
+// exports. WriteManifest is called after the last kind is written, before
+// Close. It should add the exported files to the manifest.
+type ManifestWriter interface {
+	WriteManifest(m *Manifest) error
+}
+
+// newManifest returns the manifest of the export of the dataset, without
+// files.
+func newManifest(d *dataset, format *Format) *Manifest {
+	opts := d.opts
+
+	mopts := &ManifestOptions{
+		History:     opts.History,
+		Vectors:     opts.Vectors,
+		Relational:  opts.Relational,
+		Kinds:       opts.Kinds,
+		IncludeKeys: opts.IncludeKeys,
+		ExcludeKeys: opts.ExcludeKeys,
+		Games:       opts.Games,
+		Incremental: opts.Checkpoint != "",
+	}
+
+	if !opts.Since.IsZero() {
+		mopts.Since = opts.Since.Format(time.RFC3339Nano)
+	}
+
+	if !opts.Until.IsZero() {
+		mopts.Until = opts.Until.Format(time.RFC3339Nano)
+	}
+
+	if opts.Anonymize != nil {
+		mopts.Anonymize = opts.Anonymize.Rules
+	}
+
+	return &Manifest{
+		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		Build:     build.Current(),
+		Project:   opts.Project,
+		Format:    format.Name,
+		Sources:   d.sourceInfos,
+		Options:   mopts,
+		Files:     []*FileInfo{},
+	}
+}
+
+func writeManifest(w io.Writer, m *Manifest) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	return errors.Wrap(enc.Encode(m), "write manifest")
+}
+
+// hashReader computes the size and SHA-256 of the data read from r.
+type hashReader struct {
+	r    io.Reader
+	size int64
+	hash hash.Hash
+}
+
+func newHashReader(r io.Reader) *hashReader {
+	return &hashReader{r: r, hash: sha256.New()}
+}
+
+// resumeHashReader returns a hashReader continuing the hash of size bytes read
+// before, from its state, see hashReader.state.
+func resumeHashReader(r io.Reader, size int64, state []byte) (*hashReader, error) {
+	h := sha256.New()
+
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, errors.Wrap(err, "restore hash")
+	}
+
+	return &hashReader{r: r, size: size, hash: h}, nil
+}
+
+func (h *hashReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.size += int64(n)
+	h.hash.Write(p[:n])
+
+	return n, err
+}
+
+// state returns the state of the hash, to resume it with resumeHashReader.
+func (h *hashReader) state() ([]byte, error) {
+	b, err := h.hash.(encoding.BinaryMarshaler).MarshalBinary()
+
+	return b, errors.Wrap(err, "save hash")
+}
+
+// info returns the SourceInfo of the data read, named.
+func (h *hashReader) info(name string) *SourceInfo {
+	return &SourceInfo{
+		Name:   name,
+		Size:   h.size,
+		SHA256: hex.EncodeToString(h.hash.Sum(nil)),
+	}
+}
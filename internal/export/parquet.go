@@ -0,0 +1,179 @@
+package export
+
+import (
+	"github.com/parquet-go/parquet-go"
+	"github.com/pkg/errors"
+)
+
+// parquetRowGroupSize is the maximum number of rows buffered before being
+// written out as a row group.
+const parquetRowGroupSize = 10000
+
+var parquetFormat = &Format{
+	Name:      FormatParquet,
+	Ext:       "zip",
+	NewWriter: newParquetWriter,
+}
+
+// ExportParquet exports the data of the tajriba file to a zip file containing
+// a Parquet file per kind of Scope. Column types are inferred from the values
+// of each key. Objects, and keys with values of mixed types, are stored as
+// JSON.
+func ExportParquet(tajfile, filename string, opts *Options) error {
+	return exportFormat(tajfile, filename, opts, parquetFormat)
+}
+
+func newParquetWriter(filename string, _ *Options) (Writer, error) {
+	return NewZipWriter(filename, func(z *Zip, kind *Kind) error {
+		if err := writeKindParquet(z, kind); err != nil {
+			return err
+		}
+
+		if kind.HasHistory() {
+			return writeHistoryParquet(z, kind)
+		}
+
+		return nil
+	})
+}
+
+func (k *keyType) node() parquet.Node {
+	if k.vector && k.scalar {
+		return parquet.Optional(parquet.JSON())
+	}
+
+	if k.vector {
+		// Elements of lists are required, vectors with null elements are
+		// stored as lists of JSON values.
+		return parquet.Optional(parquet.List(leafNode(k.typ)))
+	}
+
+	return parquet.Optional(leafNode(k.typ))
+}
+
+func leafNode(typ valueType) parquet.Node {
+	switch typ {
+	case typeBool:
+		return parquet.Leaf(parquet.BooleanType)
+	case typeInt:
+		return parquet.Int(64)
+	case typeFloat:
+		return parquet.Leaf(parquet.DoubleType)
+	case typeString:
+		return parquet.String()
+	default:
+		return parquet.JSON()
+	}
+}
+
+func writeKindParquet(z *Zip, kind *Kind) error {
+	types, err := keyTypes(kind)
+	if err != nil {
+		return err
+	}
+
+	group := parquet.Group{"id": parquet.String()}
+	if kind.Merged {
+		group["source"] = parquet.String()
+	}
+
+	for _, key := range kind.Relations {
+		group[key] = parquet.Optional(parquet.String())
+	}
+
+	for key, t := range types {
+		group[key] = t.node()
+		group[key+"LastChangedAt"] = parquet.Optional(parquet.Timestamp(parquet.Nanosecond))
+	}
+
+	zf, err := z.Create(camelCase(kind.Name) + ".parquet")
+	if err != nil {
+		return err
+	}
+
+	w := parquet.NewWriter(zf,
+		parquet.NewSchema(camelCase(kind.Name), group),
+		parquet.MaxRowsPerRowGroup(parquetRowGroupSize),
+	)
+
+	err = kind.EachScope(func(scope *Scope) error {
+		row := map[string]interface{}{"id": scope.ID}
+		if kind.Merged {
+			row["source"] = scope.Source
+		}
+
+		for _, key := range kind.Relations {
+			if v, ok := decodeValue(scope.relationValue(key)).(string); ok {
+				row[key] = v
+			}
+		}
+
+		for key, attribute := range scope.Attributes {
+			if kind.isRelation(key) {
+				continue
+			}
+
+			row[key] = types[key].value(attribute)
+			row[key+"LastChangedAt"] = parseTime(attribute.Last)
+		}
+
+		zf.Rows++
+
+		return errors.Wrap(w.Write(row), "write parquet")
+	})
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(w.Close(), "close parquet")
+}
+
+func writeHistoryParquet(z *Zip, kind *Kind) error {
+	group := parquet.Group{
+		"scopeID":   parquet.String(),
+		"key":       parquet.String(),
+		"value":     parquet.Optional(parquet.JSON()),
+		"index":     parquet.Optional(parquet.Int(64)),
+		"createdAt": parquet.Optional(parquet.Timestamp(parquet.Nanosecond)),
+		"creatorID": parquet.String(),
+	}
+	if kind.Merged {
+		group["source"] = parquet.String()
+	}
+
+	zf, err := z.Create("history/" + camelCase(kind.Name) + ".parquet")
+	if err != nil {
+		return err
+	}
+
+	w := parquet.NewWriter(zf,
+		parquet.NewSchema(camelCase(kind.Name)+"History", group),
+		parquet.MaxRowsPerRowGroup(parquetRowGroupSize),
+	)
+
+	err = kind.EachChange(func(change *Change) error {
+		row := map[string]interface{}{
+			"scopeID":   change.ScopeID,
+			"key":       change.Key,
+			"value":     goValue(typeJSON, change.Value),
+			"createdAt": parseTime(change.CreatedAt),
+			"creatorID": change.CreatorID,
+		}
+		if kind.Merged {
+			row["source"] = change.Source
+		}
+
+		if change.IsVector {
+			row["index"] = int64(change.Index)
+		}
+
+		zf.Rows++
+
+		return errors.Wrap(w.Write(row), "write parquet")
+	})
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(w.Close(), "close parquet")
+}
@@ -0,0 +1,89 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// schemaString returns the schema of a column, as printed in parquet files.
+func schemaString(t *testing.T, name string, node parquet.Node) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := parquet.PrintSchemaIndent(&buf, name, node, "", " "); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func TestExportParquet(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "export.zip")
+	if err := Export(typedTajfile(t), filename, &Options{Format: FormatParquet}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	b := readZipFile(t, filename, "game.parquet")
+
+	f, err := parquet.OpenFile(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("open parquet: %v", err)
+	}
+
+	rows := make([]map[string]interface{}, 0, 1)
+
+	r := parquet.NewReader(f)
+	defer r.Close()
+
+	for {
+		row := make(map[string]interface{})
+		if err := r.Read(&row); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("read parquet: %v", err)
+		}
+
+		rows = append(rows, row)
+	}
+
+	if len(rows) != 1 || rows[0]["count"] != int64(3) || rows[0]["done"] != true {
+		t.Errorf("got rows %v, want one game with count 3 and done", rows)
+	}
+
+	tests := []struct {
+		key  string
+		want parquet.Node
+	}{
+		{"id", parquet.String()},
+		{"count", parquet.Optional(parquet.Int(64))},
+		{"ratio", parquet.Optional(parquet.Leaf(parquet.DoubleType))},
+		{"done", parquet.Optional(parquet.Leaf(parquet.BooleanType))},
+		{"name", parquet.Optional(parquet.String())},
+		{"config", parquet.Optional(parquet.JSON())},
+		{"chat", parquet.Optional(parquet.List(parquet.String()))},
+		{"countLastChangedAt", parquet.Optional(parquet.Timestamp(parquet.Nanosecond))},
+	}
+
+	fields := make(map[string]parquet.Field)
+	for _, field := range f.Schema().Fields() {
+		fields[field.Name()] = field
+	}
+
+	for _, tt := range tests {
+		field, ok := fields[tt.key]
+		if !ok {
+			t.Errorf("missing %s column", tt.key)
+
+			continue
+		}
+
+		if got, want := schemaString(t, tt.key, field), schemaString(t, tt.key, tt.want); got != want {
+			t.Errorf("got %s column %s, want %s", tt.key, got, want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// readerBufferSize is the size of the read buffer on the tajriba file. Lines
+// longer than the buffer are still read in full, the buffer only sets the size
+// of individual reads.
+const readerBufferSize = 256 * 1024
+
+// eachLine calls fn with every non-empty line of r. Lines can be of any
+// length. The line passed to fn is only valid until fn returns.
+func eachLine(r io.Reader, fn func(line []byte) error) error {
+	br := bufio.NewReaderSize(r, readerBufferSize)
+
+	var buf []byte
+
+	for {
+		chunk, err := br.ReadSlice('\n')
+
+		switch {
+		case err == nil:
+			// Full line in the reader buffer, avoid the copy if possible.
+			if len(buf) > 0 {
+				buf = append(buf, chunk...)
+				chunk = buf
+			}
+		case errors.Is(err, bufio.ErrBufferFull):
+			buf = append(buf, chunk...)
+
+			continue
+		case errors.Is(err, io.EOF):
+			if len(buf) > 0 {
+				buf = append(buf, chunk...)
+				chunk = buf
+			}
+		default:
+			return errors.Wrap(err, "read line")
+		}
+
+		if line := bytes.TrimSpace(chunk); len(line) > 0 {
+			if ferr := fn(line); ferr != nil {
+				return ferr
+			}
+		}
+
+		buf = buf[:0]
+
+		if err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEachLine(t *testing.T) {
+	long := strings.Repeat("x", 3*readerBufferSize+17)
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "lines",
+			input: "a\nb\nc\n",
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "no final newline",
+			input: "a\nb",
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "empty lines and spaces",
+			input: "\n  a \n\n\r\nb\n\n",
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "longer than the buffer",
+			input: "a\n" + long + "\nb\n",
+			want:  []string{"a", long, "b"},
+		},
+		{
+			name:  "longer than the buffer at the end",
+			input: "a\n" + long,
+			want:  []string{"a", long},
+		},
+		{
+			name:  "empty",
+			input: "",
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+
+			err := eachLine(strings.NewReader(tt.input), func(line []byte) error {
+				got = append(got, string(line))
+
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("eachLine: %v", err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d lines, want %d", len(got), len(tt.want))
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("line %d: got %d bytes %.20q, want %d bytes %.20q",
+						i, len(got[i]), got[i], len(tt.want[i]), tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEachLineReuse(t *testing.T) {
+	// Lines are only valid until fn returns, a line spanning several reads
+	// must not be overwritten by the next one before it is passed.
+	long := bytes.Repeat([]byte("y"), 2*readerBufferSize)
+	input := string(long) + "\n" + string(long[:10]) + "\n"
+
+	var lens []int
+
+	err := eachLine(strings.NewReader(input), func(line []byte) error {
+		if len(bytes.Trim(line, "y")) > 0 {
+			t.Errorf("unexpected content in line of %d bytes", len(line))
+		}
+
+		lens = append(lens, len(line))
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("eachLine: %v", err)
+	}
+
+	if len(lens) != 2 || lens[0] != len(long) || lens[1] != 10 {
+		t.Errorf("got line lengths %v, want [%d 10]", lens, len(long))
+	}
+}
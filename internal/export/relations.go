@@ -0,0 +1,139 @@
+package export
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// relations are the foreign keys of the Scope kinds created by Empirica.
+var relations = map[string][]string{
+	"game":        {"batchID"},
+	"round":       {"batchID", "gameID"},
+	"stage":       {"batchID", "gameID", "roundID"},
+	"playerGame":  {"batchID", "gameID", "playerID"},
+	"playerRound": {"batchID", "gameID", "roundID", "playerID"},
+	"playerStage": {"batchID", "gameID", "roundID", "stageID", "playerID"},
+}
+
+// links are the prefixes of the keys set on players, suffixed with the ID of a
+// game, round or stage, which hold the ID of the playerGame, playerRound or
+// playerStage Scope of the player for that game, round or stage.
+var links = []struct {
+	prefix string
+	key    string
+}{
+	{"playerGameID-", "gameID"},
+	{"playerRoundID-", "roundID"},
+	{"playerStageID-", "stageID"},
+}
+
+func isLink(e *entry) bool {
+	for _, l := range links {
+		if strings.HasPrefix(e.Key, l.prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// linkedGame returns the ID of the game of a playerGameID- link key.
+func linkedGame(e *entry) (string, bool) {
+	if e.Link || !strings.HasPrefix(e.Key, links[0].prefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(e.Key, links[0].prefix), true
+}
+
+// linkEntries converts a link key set on a player into the playerID and
+// gameID, roundID or stageID attributes of the linked Scope. The linked Scope
+// already has these attributes when created by recent versions of Empirica,
+// link entries only fill them in if missing.
+func linkEntries(e *entry) []*entry {
+	var linkedID string
+	if err := json.Unmarshal([]byte(e.Val), &linkedID); err != nil || linkedID == "" {
+		return nil
+	}
+
+	for _, l := range links {
+		if !strings.HasPrefix(e.Key, l.prefix) {
+			continue
+		}
+
+		entries := make([]*entry, 0, 2)
+
+		for _, kv := range [][2]string{
+			{"playerID", e.ScopeID},
+			{l.key, strings.TrimPrefix(e.Key, l.prefix)},
+		} {
+			key, id := kv[0], kv[1]
+			val, _ := json.Marshal(id)
+
+			entries = append(entries, &entry{
+				ScopeID:   linkedID,
+				Seq:       e.Seq,
+				Key:       key,
+				Val:       string(val),
+				CreatedAt: e.CreatedAt,
+				CreatorID: e.CreatorID,
+				Link:      true,
+			})
+		}
+
+		return entries
+	}
+
+	return nil
+}
+
+// setRelations moves the foreign keys of the kind from Keys to Relations.
+func (k *Kind) setRelations() {
+	k.Relations = relations[camelCase(k.Name)]
+
+	if len(k.Relations) == 0 {
+		return
+	}
+
+	keys := k.Keys[:0]
+
+	for _, key := range k.Keys {
+		if !k.isRelation(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	k.Keys = keys
+}
+
+// isRelationKey returns true if key is a foreign key of the kind.
+func isRelationKey(kind, key string) bool {
+	for _, r := range relations[camelCase(kind)] {
+		if r == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (k *Kind) isRelation(key string) bool {
+	for _, r := range k.Relations {
+		if r == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// relationValue returns the JSON encoded value of a foreign key of the Scope,
+// or an empty string.
+func (s *Scope) relationValue(key string) string {
+	attribute, ok := s.Attributes[key]
+	if !ok || attribute.IsVector {
+		return ""
+	}
+
+	return attribute.Value
+}
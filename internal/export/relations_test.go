@@ -0,0 +1,104 @@
+package export
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestExportRelational(t *testing.T) {
+	tajfile := writeTajfile(t,
+		[][2]string{
+			{"b1", "batch"}, {"g1", "game"}, {"r1", "round"}, {"s1", "stage"},
+			{"p1", "player"}, {"pg1", "playerGame"}, {"pr1", "playerRound"}, {"ps1", "playerStage"},
+		},
+		[][3]string{
+			{"g1", "batchID", `"b1"`},
+			{"r1", "batchID", `"b1"`},
+			{"r1", "gameID", `"g1"`},
+			{"s1", "gameID", `"g1"`},
+			{"s1", "roundID", `"r1"`},
+			{"p1", "playerGameID-g1", `"pg1"`},
+			{"p1", "playerRoundID-r1", `"pr1"`},
+			{"p1", "playerStageID-s1", `"ps1"`},
+			{"pg1", "score", `1`},
+			{"pr1", "score", `2`},
+			{"ps1", "score", `3`},
+		})
+
+	tests := []struct {
+		name       string
+		relational bool
+		file       string
+		want       []map[string]string
+		missing    []string
+	}{
+		{
+			name:       "game of rounds",
+			relational: true,
+			file:       "round.csv",
+			want:       []map[string]string{{"id": "r1", "batchID": "b1", "gameID": "g1"}},
+			missing:    []string{"batchIDLastChangedAt", "gameIDLastChangedAt"},
+		},
+		{
+			name:       "stage without batch",
+			relational: true,
+			file:       "stage.csv",
+			want:       []map[string]string{{"id": "s1", "batchID": "", "gameID": "g1", "roundID": "r1"}},
+		},
+		{
+			name:       "playerGame join table from the player links",
+			relational: true,
+			file:       "playerGame.csv",
+			want:       []map[string]string{{"id": "pg1", "gameID": "g1", "playerID": "p1", "score": "1"}},
+		},
+		{
+			name:       "playerRound join table",
+			relational: true,
+			file:       "playerRound.csv",
+			want:       []map[string]string{{"id": "pr1", "roundID": "r1", "playerID": "p1", "score": "2"}},
+		},
+		{
+			name:       "playerStage join table",
+			relational: true,
+			file:       "playerStage.csv",
+			want:       []map[string]string{{"id": "ps1", "stageID": "s1", "playerID": "p1", "score": "3"}},
+		},
+		{
+			name:       "players without link keys",
+			relational: true,
+			file:       "player.csv",
+			want:       []map[string]string{{"id": "p1"}},
+			missing:    []string{"playerGameID-g1", "playerRoundID-r1", "playerStageID-s1"},
+		},
+		{
+			name:    "flat export without relations",
+			file:    "playerGame.csv",
+			want:    []map[string]string{{"id": "pg1", "score": "1"}},
+			missing: []string{"gameID", "playerID"},
+		},
+		{
+			name:    "flat export of foreign keys as attributes",
+			file:    "round.csv",
+			want:    []map[string]string{{"id": "r1", "gameID": "g1", "gameIDLastChangedAt": "2023-01-01T00:00:02Z"}},
+			missing: []string{"roundID"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "export.zip")
+			if err := Export(tajfile, filename, &Options{Relational: tt.relational}); err != nil {
+				t.Fatalf("export: %v", err)
+			}
+
+			rows := readZipRows(t, filename, tt.file)
+			checkRows(t, tt.file, rows, tt.want)
+
+			for _, col := range tt.missing {
+				if _, ok := rows[0][col]; ok {
+					t.Errorf("got %s column in %s", col, tt.file)
+				}
+			}
+		})
+	}
+}
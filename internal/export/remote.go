@@ -0,0 +1,477 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// remoteServiceName is the name of the service registered on the tajriba
+	// server to export from.
+	remoteServiceName = "export"
+
+	// remotePageSize is the number of Scopes, attributes and versions of an
+	// attribute fetched per request.
+	remotePageSize = 100
+
+	remoteTimeout = 5 * time.Minute
+)
+
+const remoteActorFields = `createdBy {
+	... on Participant { id }
+	... on Service { id }
+	... on User { id }
+}`
+
+const remoteAttributeFields = `id key val index vector version createdAt ` + remoteActorFields
+
+const remoteVersionsQuery = `versions(first: $versions, after: $versionsAfter) {
+	pageInfo { hasNextPage endCursor }
+	edges { node { ` + remoteAttributeFields + ` } }
+}`
+
+const remoteAttributesQuery = `attributes(first: $attributes, after: $after) {
+	pageInfo { hasNextPage endCursor }
+	edges { cursor node {
+		` + remoteAttributeFields + `
+		` + remoteVersionsQuery + ` @include(if: $history)
+	} }
+}`
+
+const remoteScopesQuery = `query Scopes($first: Int, $cursor: Cursor, $attributes: Int, $after: Cursor, $versions: Int, $versionsAfter: Cursor, $history: Boolean!) {
+	scopes(first: $first, after: $cursor) {
+		pageInfo { hasNextPage endCursor }
+		edges { node {
+			id kind createdAt ` + remoteActorFields + `
+			` + remoteAttributesQuery + `
+		} }
+	}
+}`
+
+const remoteScopeAttributesQuery = `query ScopeAttributes($id: ID!, $attributes: Int, $after: Cursor, $versions: Int, $versionsAfter: Cursor, $history: Boolean!) {
+	scopes(first: 1, filter: [{ids: [$id]}]) {
+		edges { node { ` + remoteAttributesQuery + ` } }
+	}
+}`
+
+// remoteAttributeVersionsQuery fetches the next page of versions of the
+// attribute following the $after cursor in the attributes of the Scope.
+const remoteAttributeVersionsQuery = `query AttributeVersions($id: ID!, $after: Cursor, $versions: Int, $versionsAfter: Cursor) {
+	scopes(first: 1, filter: [{ids: [$id]}]) {
+		edges { node {
+			attributes(first: 1, after: $after) {
+				edges { node { id ` + remoteVersionsQuery + ` } }
+			}
+		} }
+	}
+}`
+
+const remoteRegisterMutation = `mutation RegisterService($input: RegisterServiceInput!) {
+	registerService(input: $input) { sessionToken }
+}`
+
+type remoteActor struct {
+	ID string `json:"id"`
+}
+
+type remotePageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+type remoteAttribute struct {
+	ID        string          `json:"id"`
+	Key       string          `json:"key"`
+	Val       *string         `json:"val"`
+	Index     *int            `json:"index"`
+	Vector    bool            `json:"vector"`
+	Version   int             `json:"version"`
+	CreatedAt string          `json:"createdAt"`
+	CreatedBy *remoteActor    `json:"createdBy"`
+	Versions  *remoteVersions `json:"versions"`
+}
+
+type remoteVersions struct {
+	PageInfo remotePageInfo `json:"pageInfo"`
+	Edges    []struct {
+		Node *remoteAttribute `json:"node"`
+	} `json:"edges"`
+}
+
+type remoteAttributes struct {
+	PageInfo remotePageInfo `json:"pageInfo"`
+	Edges    []struct {
+		Cursor string           `json:"cursor"`
+		Node   *remoteAttribute `json:"node"`
+	} `json:"edges"`
+}
+
+type remoteScope struct {
+	ID         string            `json:"id"`
+	Kind       string            `json:"kind"`
+	CreatedAt  string            `json:"createdAt"`
+	CreatedBy  *remoteActor      `json:"createdBy"`
+	Attributes *remoteAttributes `json:"attributes"`
+}
+
+type remoteScopes struct {
+	Scopes struct {
+		PageInfo remotePageInfo `json:"pageInfo"`
+		Edges    []struct {
+			Node *remoteScope `json:"node"`
+		} `json:"edges"`
+	} `json:"scopes"`
+}
+
+// remote is a client of the GraphQL API of a running tajriba server.
+type remote struct {
+	url     string
+	token   string
+	history bool
+	client  *http.Client
+}
+
+// ExportRemote exports the data of a running tajriba server, from its GraphQL
+// endpoint at url, like Export does from a tajriba file. token is the service
+// registration token of the server, used to register an "export" service on
+// the server. The data is first downloaded to a temporary tajriba file.
+func ExportRemote(ctx context.Context, url, token, filename string, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if opts.Checkpoint != "" {
+		return errors.New("incremental export is not supported from a server")
+	}
+
+	// Fail before downloading, and download every version of attributes for
+	// formats always exporting the history.
+	format, ok := LookupFormat(opts.Format)
+	if !ok {
+		return errors.Errorf("unknown export format: %s", opts.Format)
+	}
+
+	file, err := os.CreateTemp("", "empirica-export-*.json")
+	if err != nil {
+		return errors.Wrap(err, "create temporary file")
+	}
+	defer os.Remove(file.Name())
+
+	r := &remote{
+		url:     url,
+		history: opts.History || format.History,
+		client:  &http.Client{Timeout: remoteTimeout},
+	}
+
+	w := bufio.NewWriter(file)
+
+	if err := r.download(ctx, token, w); err != nil {
+		file.Close()
+
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		file.Close()
+
+		return errors.Wrap(err, "write temporary file")
+	}
+
+	if err := file.Close(); err != nil {
+		return errors.Wrap(err, "close temporary file")
+	}
+
+	if opts.Source == "" {
+		o := *opts
+		o.Source = url
+		opts = &o
+	}
+
+	return Export(file.Name(), filename, opts)
+}
+
+// download writes the Scopes and attributes of the server to w, in the format
+// of a tajriba file. Every version of attributes is written if history is set,
+// only the current version otherwise.
+func (r *remote) download(ctx context.Context, token string, w io.Writer) error {
+	var reg struct {
+		RegisterService struct {
+			SessionToken string `json:"sessionToken"`
+		} `json:"registerService"`
+	}
+
+	err := r.query(ctx, remoteRegisterMutation, map[string]interface{}{
+		"input": map[string]interface{}{
+			"name":  remoteServiceName,
+			"token": token,
+		},
+	}, &reg)
+	if err != nil {
+		return errors.Wrap(err, "register service")
+	}
+
+	r.token = reg.RegisterService.SessionToken
+
+	var cursor interface{}
+
+	count := 0
+
+	for {
+		var res remoteScopes
+
+		err := r.query(ctx, remoteScopesQuery, r.vars(map[string]interface{}{
+			"first":  remotePageSize,
+			"cursor": cursor,
+		}), &res)
+		if err != nil {
+			return errors.Wrap(err, "fetch scopes")
+		}
+
+		for _, edge := range res.Scopes.Edges {
+			if err := r.writeScope(ctx, w, edge.Node); err != nil {
+				return err
+			}
+		}
+
+		count += len(res.Scopes.Edges)
+
+		log.Debug().Int("scopes", count).Msg("export: fetched scopes")
+
+		if !res.Scopes.PageInfo.HasNextPage {
+			return nil
+		}
+
+		cursor = res.Scopes.PageInfo.EndCursor
+	}
+}
+
+func (r *remote) vars(vars map[string]interface{}) map[string]interface{} {
+	vars["attributes"] = remotePageSize
+	vars["versions"] = remotePageSize
+	vars["history"] = r.history
+
+	return vars
+}
+
+// writeScope writes the Scope and its attributes, fetching the remaining
+// pages of attributes of the Scope. Attributes are written in the order they
+// were created, as in a tajriba file.
+func (r *remote) writeScope(ctx context.Context, w io.Writer, scope *remoteScope) error {
+	err := writeRecord(w, "Scope", map[string]interface{}{
+		"id":        scope.ID,
+		"kind":      scope.Kind,
+		"createdAt": scope.CreatedAt,
+		"creatorID": actorID(scope.CreatedBy),
+	})
+	if err != nil {
+		return err
+	}
+
+	var (
+		versions []*remoteAttribute
+
+		// after is the cursor of the attribute before the current one, nil
+		// for the first attribute.
+		after interface{}
+	)
+
+	attrs := scope.Attributes
+
+	for attrs != nil {
+		for _, edge := range attrs.Edges {
+			v, err := r.attributeVersions(ctx, scope.ID, after, edge.Node)
+			if err != nil {
+				return err
+			}
+
+			versions = append(versions, v...)
+			after = edge.Cursor
+		}
+
+		if !attrs.PageInfo.HasNextPage {
+			break
+		}
+
+		var res remoteScopes
+
+		err := r.query(ctx, remoteScopeAttributesQuery, r.vars(map[string]interface{}{
+			"id":    scope.ID,
+			"after": attrs.PageInfo.EndCursor,
+		}), &res)
+		if err != nil {
+			return errors.Wrap(err, "fetch attributes")
+		}
+
+		attrs = nil
+
+		if len(res.Scopes.Edges) == 1 {
+			attrs = res.Scopes.Edges[0].Node.Attributes
+		}
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		ti, _ := time.Parse(time.RFC3339Nano, versions[i].CreatedAt)
+		tj, _ := time.Parse(time.RFC3339Nano, versions[j].CreatedAt)
+
+		return ti.Before(tj)
+	})
+
+	for _, v := range versions {
+		obj := map[string]interface{}{
+			"id":        v.ID,
+			"key":       v.Key,
+			"val":       v.Val,
+			"vector":    v.Vector,
+			"nodeID":    scope.ID,
+			"createdAt": v.CreatedAt,
+			"creatorID": actorID(v.CreatedBy),
+			"version":   v.Version,
+		}
+
+		if v.Index != nil {
+			obj["index"] = *v.Index
+		}
+
+		if err := writeRecord(w, "Attribute", obj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// attributeVersions returns the previous versions of the attribute of the
+// Scope, if fetched, oldest first, followed by the attribute itself. The pages
+// of versions following the first one are fetched with the cursor of the
+// attribute before it, after.
+func (r *remote) attributeVersions(ctx context.Context, scopeID string, after interface{}, attr *remoteAttribute) ([]*remoteAttribute, error) {
+	versions := []*remoteAttribute{}
+
+	for page := attr.Versions; page != nil; {
+		for _, edge := range page.Edges {
+			if edge.Node.Version < attr.Version {
+				versions = append(versions, edge.Node)
+			}
+		}
+
+		if !page.PageInfo.HasNextPage {
+			break
+		}
+
+		var res remoteScopes
+
+		err := r.query(ctx, remoteAttributeVersionsQuery, map[string]interface{}{
+			"id":            scopeID,
+			"after":         after,
+			"versions":      remotePageSize,
+			"versionsAfter": page.PageInfo.EndCursor,
+		}, &res)
+		if err != nil {
+			return nil, errors.Wrap(err, "fetch versions")
+		}
+
+		page = nil
+
+		if len(res.Scopes.Edges) == 1 && res.Scopes.Edges[0].Node.Attributes != nil {
+			for _, edge := range res.Scopes.Edges[0].Node.Attributes.Edges {
+				if edge.Node.ID == attr.ID {
+					page = edge.Node.Versions
+				}
+			}
+		}
+
+		if page == nil {
+			return nil, errors.Errorf("fetch versions: attribute %s not found", attr.ID)
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+
+	return append(versions, attr), nil
+}
+
+func writeRecord(w io.Writer, kind string, obj interface{}) error {
+	b, err := json.Marshal(map[string]interface{}{"kind": kind, "obj": obj})
+	if err != nil {
+		return errors.Wrap(err, "encode record")
+	}
+
+	b = append(b, '\n')
+
+	_, err = w.Write(b)
+
+	return errors.Wrap(err, "write record")
+}
+
+func actorID(actor *remoteActor) string {
+	if actor == nil {
+		return ""
+	}
+
+	return actor.ID
+}
+
+// query runs a GraphQL query and decodes its data in res.
+func (r *remote) query(ctx context.Context, query string, vars map[string]interface{}, res interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": vars,
+	})
+	if err != nil {
+		return errors.Wrap(err, "encode query")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send request")
+	}
+	defer resp.Body.Close()
+
+	var payload struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return errors.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+
+		return errors.Wrap(err, "decode response")
+	}
+
+	if len(payload.Errors) > 0 {
+		return errors.New(payload.Errors[0].Message)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return errors.Wrap(json.Unmarshal(payload.Data, res), "decode data")
+}
@@ -0,0 +1,354 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakePageSize is the size of the pages of the fake server, smaller than the
+// pages requested, as servers may return.
+const fakePageSize = 2
+
+type fakeScope struct {
+	id    string
+	kind  string
+	attrs []*fakeAttribute
+}
+
+// fakeAttribute is an attribute of a fake server, with every value it had,
+// the last being the current one.
+type fakeAttribute struct {
+	key  string
+	vals []string
+}
+
+// fakeServer is a GraphQL server answering the queries of ExportRemote, with
+// the scopes in order. errors are the error messages returned by operation.
+type fakeServer struct {
+	scopes []*fakeScope
+	errors map[string]string
+
+	registered string
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	op := strings.Fields(req.Query)[1]
+	op = op[:strings.Index(op, "(")]
+
+	if msg, ok := f.errors[op]; ok {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"errors": []interface{}{map[string]interface{}{"message": msg}},
+		})
+
+		return
+	}
+
+	var data interface{}
+
+	switch op {
+	case "RegisterService":
+		input := req.Variables["input"].(map[string]interface{})
+		f.registered = input["name"].(string) + ":" + input["token"].(string)
+		data = map[string]interface{}{"registerService": map[string]interface{}{"sessionToken": "session"}}
+	case "Scopes", "ScopeAttributes", "AttributeVersions":
+		if r.Header.Get("Authorization") != "Bearer session" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		data = map[string]interface{}{"scopes": f.scopesConnection(op, req.Variables)}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func (f *fakeServer) scopesConnection(op string, vars map[string]interface{}) map[string]interface{} {
+	history, _ := vars["history"].(bool)
+
+	nodes := make([]interface{}, 0, len(f.scopes))
+
+	for _, s := range f.scopes {
+		if op != "Scopes" && s.id != vars["id"] {
+			continue
+		}
+
+		attrs := make([]interface{}, len(s.attrs))
+		for i, a := range s.attrs {
+			attrs[i] = a.node(s.id, i, op == "AttributeVersions" || history, vars["versionsAfter"])
+		}
+
+		size := fakePageSize
+		if op == "AttributeVersions" {
+			size = 1
+		}
+
+		nodes = append(nodes, map[string]interface{}{
+			"id":         s.id,
+			"kind":       s.kind,
+			"createdAt":  fakeTime(s.id, 0),
+			"createdBy":  map[string]interface{}{"id": "admin"},
+			"attributes": fakeConnection(attrs, vars["after"], size),
+		})
+	}
+
+	if op != "Scopes" {
+		return fakeConnection(nodes, nil, 1)
+	}
+
+	return fakeConnection(nodes, vars["cursor"], fakePageSize)
+}
+
+// node returns the GraphQL node of the attribute, the ith of the scope, with
+// the page of its versions after versionsAfter if history is set.
+func (a *fakeAttribute) node(scopeID string, i int, history bool, versionsAfter interface{}) map[string]interface{} {
+	versions := make([]interface{}, len(a.vals))
+
+	for v := range a.vals {
+		versions[v] = map[string]interface{}{
+			"id":        fmt.Sprintf("%s-%s-%d", scopeID, a.key, v+1),
+			"key":       a.key,
+			"val":       a.vals[v],
+			"vector":    false,
+			"version":   v + 1,
+			"createdAt": fakeTime(scopeID, (i+1)*10+v),
+			"createdBy": map[string]interface{}{"id": "player"},
+		}
+	}
+
+	node := make(map[string]interface{})
+	for k, v := range versions[len(versions)-1].(map[string]interface{}) {
+		node[k] = v
+	}
+
+	if history {
+		node["versions"] = fakeConnection(versions, versionsAfter, fakePageSize)
+	}
+
+	return node
+}
+
+// fakeTime returns the creation time of the nth record of the scope. Scopes
+// are created in the order of their IDs, their attributes after them.
+func fakeTime(scopeID string, n int) string {
+	return fmt.Sprintf("2023-01-01T%s:%02d:%02dZ", map[string]string{"g1": "01", "p1": "02", "pg1": "03"}[scopeID], n/60, n%60)
+}
+
+// fakeConnection returns the page of nodes after the cursor, the index of the
+// last node of the previous page.
+func fakeConnection(nodes []interface{}, after interface{}, size int) map[string]interface{} {
+	start := 0
+	if s, ok := after.(string); ok {
+		start, _ = strconv.Atoi(s)
+	}
+
+	if start > len(nodes) {
+		start = len(nodes)
+	}
+
+	end := start + size
+	if end > len(nodes) {
+		end = len(nodes)
+	}
+
+	edges := make([]interface{}, 0, end-start)
+	for i := start; i < end; i++ {
+		edges = append(edges, map[string]interface{}{"cursor": strconv.Itoa(i + 1), "node": nodes[i]})
+	}
+
+	return map[string]interface{}{
+		"pageInfo": map[string]interface{}{"hasNextPage": end < len(nodes), "endCursor": strconv.Itoa(end)},
+		"edges":    edges,
+	}
+}
+
+// fakeScopes are a game, a player with a link to its playerGame Scope, served
+// after the player, and the playerGame Scope, with more attributes and
+// versions of attributes than fit in a page.
+func fakeScopes() []*fakeScope {
+	return []*fakeScope{
+		{id: "g1", kind: "game", attrs: []*fakeAttribute{
+			{key: "status", vals: []string{`"created"`, `"running"`, `"ended"`}},
+			{key: "a", vals: []string{`1`}},
+			{key: "b", vals: []string{`2`}},
+			{key: "c", vals: []string{`3`}},
+			{key: "d", vals: []string{`4`}},
+		}},
+		{id: "p1", kind: "player", attrs: []*fakeAttribute{
+			{key: "playerGameID-g1", vals: []string{`"pg1"`}},
+			{key: "name", vals: []string{`"ann"`, `"anna"`}},
+		}},
+		{id: "pg1", kind: "playerGame", attrs: []*fakeAttribute{
+			{key: "score", vals: []string{`1`, `2`, `3`, `4`, `5`}},
+		}},
+	}
+}
+
+func TestExportRemote(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *Options
+		file string
+		want []map[string]string
+	}{
+		{
+			name: "last values",
+			opts: &Options{},
+			file: "game.csv",
+			want: []map[string]string{{"id": "g1", "status": "ended", "a": "1", "b": "2", "c": "3", "d": "4"}},
+		},
+		{
+			name: "relational with player before its playerGame",
+			opts: &Options{Relational: true},
+			file: "playerGame.csv",
+			want: []map[string]string{{"id": "pg1", "gameID": "g1", "playerID": "p1", "score": "5"}},
+		},
+		{
+			name: "history of versions over several pages",
+			opts: &Options{History: true},
+			file: "history/playerGame.csv",
+			want: []map[string]string{
+				{"scopeID": "pg1", "key": "score", "value": "1", "creatorID": "player"},
+				{"scopeID": "pg1", "key": "score", "value": "2", "creatorID": "player"},
+				{"scopeID": "pg1", "key": "score", "value": "3", "creatorID": "player"},
+				{"scopeID": "pg1", "key": "score", "value": "4", "creatorID": "player"},
+				{"scopeID": "pg1", "key": "score", "value": "5", "creatorID": "player"},
+			},
+		},
+		{
+			name: "history of the game",
+			opts: &Options{History: true},
+			file: "history/game.csv",
+			want: []map[string]string{
+				{"key": "a", "value": "1"},
+				{"key": "b", "value": "2"},
+				{"key": "c", "value": "3"},
+				{"key": "d", "value": "4"},
+				{"key": "status", "value": "created"},
+				{"key": "status", "value": "running"},
+				{"key": "status", "value": "ended"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeServer{scopes: fakeScopes()}
+
+			srv := httptest.NewServer(f)
+			defer srv.Close()
+
+			filename := filepath.Join(t.TempDir(), "export.zip")
+			if err := ExportRemote(context.Background(), srv.URL, "secret", filename, tt.opts); err != nil {
+				t.Fatalf("export: %v", err)
+			}
+
+			if f.registered != remoteServiceName+":secret" {
+				t.Errorf("got registered service %s", f.registered)
+			}
+
+			rows := readZipRows(t, filename, tt.file)
+			if len(rows) != len(tt.want) {
+				t.Fatalf("got %d rows in %s, want %d: %v", len(rows), tt.file, len(tt.want), rows)
+			}
+
+			for i, want := range tt.want {
+				for col, val := range want {
+					if rows[i][col] != val {
+						t.Errorf("row %d: got %s %q, want %q", i, col, rows[i][col], val)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExportRemoteErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		errors map[string]string
+		token  string
+		opts   *Options
+		want   string
+	}{
+		{
+			name:   "registration error",
+			errors: map[string]string{"RegisterService": "invalid token"},
+			want:   "register service: invalid token",
+		},
+		{
+			name:   "scopes error",
+			errors: map[string]string{"Scopes": "too many requests"},
+			want:   "fetch scopes: too many requests",
+		},
+		{
+			name:   "attributes error",
+			errors: map[string]string{"ScopeAttributes": "timeout"},
+			want:   "fetch attributes: timeout",
+		},
+		{
+			name:   "versions error",
+			errors: map[string]string{"AttributeVersions": "timeout"},
+			opts:   &Options{History: true},
+			want:   "fetch versions: timeout",
+		},
+		{
+			name: "checkpoint",
+			opts: &Options{Checkpoint: "checkpoint"},
+			want: "incremental export is not supported",
+		},
+		{
+			name: "unknown format",
+			opts: &Options{Format: "xml"},
+			want: "unknown export format: xml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(&fakeServer{scopes: fakeScopes(), errors: tt.errors})
+			defer srv.Close()
+
+			filename := filepath.Join(t.TempDir(), "export.zip")
+
+			err := ExportRemote(context.Background(), srv.URL, "secret", filename, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportRemoteUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	err := ExportRemote(context.Background(), srv.URL, "secret", filepath.Join(t.TempDir(), "export.zip"), nil)
+	if err == nil || !strings.Contains(err.Error(), "unexpected status code: 401") {
+		t.Errorf("got error %v, want status code 401", err)
+	}
+}
@@ -0,0 +1,260 @@
+package export
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// sortBufferSize is the approximate amount of memory used to hold entries
+// before they are sorted and spilled to disk.
+const sortBufferSize = 64 * 1024 * 1024
+
+// entry is a Scope or Attribute record of the tajriba file, reduced to what is
+// needed for the export.
+type entry struct {
+	ScopeID   string
+	Seq       uint64
+	IsScope   bool
+	Kind      string
+	Key       string
+	Val       string
+	Vector    bool
+	Index     int
+	CreatedAt string
+	CreatorID string
+
+	// ID is the ID of attribute records and Source the index of the tajriba
+	// file of the record, in merged exports.
+	ID     string
+	Source int
+
+	// Link entries are derived from the keys linking players to their
+	// playerGame, playerRound and playerStage Scopes.
+	Link bool
+}
+
+func (e *entry) size() int {
+	return len(e.ScopeID) + len(e.Kind) + len(e.Key) + len(e.Val) + len(e.CreatedAt) + len(e.CreatorID) + len(e.ID) + 64
+}
+
+// less orders entries by scope, with the scope record first, followed by the
+// attributes of the scope, grouped by key in the order they were written.
+func (e *entry) less(o *entry) bool {
+	if e.ScopeID != o.ScopeID {
+		return e.ScopeID < o.ScopeID
+	}
+
+	if e.IsScope != o.IsScope {
+		return e.IsScope
+	}
+
+	if e.Key != o.Key {
+		return e.Key < o.Key
+	}
+
+	return e.Seq < o.Seq
+}
+
+// sorter is an external merge sort of entries. Entries are buffered in memory
+// up to sortBufferSize, then sorted and written to a run file in dir. Runs are
+// merged back when reading.
+type sorter struct {
+	dir  string
+	buf  []*entry
+	size int
+	runs []string
+}
+
+// newSorter creates a sorter writing its runs in dir. runs are existing run
+// files, merged with the added entries.
+func newSorter(dir string, runs ...string) *sorter {
+	return &sorter{dir: dir, runs: runs}
+}
+
+func (s *sorter) add(e *entry) error {
+	s.buf = append(s.buf, e)
+	s.size += e.size()
+
+	if s.size >= sortBufferSize {
+		return s.spill()
+	}
+
+	return nil
+}
+
+// empty returns true if no entries were added to the sorter.
+func (s *sorter) empty() bool {
+	return len(s.buf) == 0 && len(s.runs) == 0
+}
+
+func (s *sorter) sortBuf() {
+	sort.Slice(s.buf, func(i, j int) bool {
+		return s.buf[i].less(s.buf[j])
+	})
+}
+
+func (s *sorter) spill() error {
+	s.sortBuf()
+
+	file, err := os.CreateTemp(s.dir, "run-*")
+	if err != nil {
+		return errors.Wrap(err, "create run file")
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	enc := gob.NewEncoder(w)
+
+	for _, e := range s.buf {
+		if err := enc.Encode(e); err != nil {
+			return errors.Wrap(err, "write run file")
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "write run file")
+	}
+
+	s.runs = append(s.runs, file.Name())
+	s.buf = nil
+	s.size = 0
+
+	return nil
+}
+
+// each calls fn with all the entries added to the sorter, in order.
+func (s *sorter) each(fn func(*entry) error) error {
+	if len(s.runs) == 0 {
+		s.sortBuf()
+
+		for _, e := range s.buf {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if len(s.buf) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	h := make(runHeap, 0, len(s.runs))
+
+	for _, run := range s.runs {
+		file, err := os.Open(run)
+		if err != nil {
+			return errors.Wrap(err, "open run file")
+		}
+		defer file.Close()
+
+		r := &runReader{dec: gob.NewDecoder(bufio.NewReader(file))}
+
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			h = append(h, r)
+		}
+	}
+
+	heap.Init(&h)
+
+	for len(h) > 0 {
+		r := h[0]
+
+		if err := fn(r.cur); err != nil {
+			return err
+		}
+
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+
+	return nil
+}
+
+// save writes all the entries added to the sorter, in order, to a single run
+// file at path.
+func (s *sorter) save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "create run file")
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	enc := gob.NewEncoder(w)
+
+	err = s.each(func(e *entry) error {
+		return errors.Wrap(enc.Encode(e), "write run file")
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "write run file")
+	}
+
+	return errors.Wrap(file.Close(), "close run file")
+}
+
+type runReader struct {
+	dec *gob.Decoder
+	cur *entry
+}
+
+func (r *runReader) next() (bool, error) {
+	e := &entry{}
+
+	if err := r.dec.Decode(e); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, "read run file")
+	}
+
+	r.cur = e
+
+	return true, nil
+}
+
+type runHeap []*runReader
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return h[i].cur.less(h[j].cur) }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *runHeap) Push(x interface{}) {
+	*h = append(*h, x.(*runReader))
+}
+
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+
+	return x
+}
@@ -0,0 +1,205 @@
+package export
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// sortEntries returns shuffled entries of scopes with their scope record and
+// attributes written several times, and the same entries in sorted order.
+func sortEntries(scopes, keys, writes int) (shuffled, sorted []*entry) {
+	var seq uint64
+
+	for s := 0; s < scopes; s++ {
+		scopeID := fmt.Sprintf("scope%03d", s)
+
+		seq++
+		sorted = append(sorted, &entry{ScopeID: scopeID, Seq: seq, IsScope: true, Kind: "game"})
+
+		for k := 0; k < keys; k++ {
+			for w := 0; w < writes; w++ {
+				seq++
+				sorted = append(sorted, &entry{
+					ScopeID: scopeID,
+					Seq:     seq,
+					Key:     fmt.Sprintf("key%02d", k),
+					Val:     fmt.Sprintf("%d", w),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].less(sorted[j]) })
+
+	shuffled = make([]*entry, len(sorted))
+	copy(shuffled, sorted)
+
+	r := rand.New(rand.NewSource(1))
+	r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	return shuffled, sorted
+}
+
+func collect(t *testing.T, s *sorter) []*entry {
+	t.Helper()
+
+	var got []*entry
+
+	err := s.each(func(e *entry) error {
+		got = append(got, e)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("each: %v", err)
+	}
+
+	return got
+}
+
+func checkOrder(t *testing.T, got, want []*entry) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(got), len(want))
+	}
+
+	for i := range got {
+		if got[i].ScopeID != want[i].ScopeID || got[i].Seq != want[i].Seq {
+			t.Fatalf("entry %d: got %s/%d, want %s/%d", i, got[i].ScopeID, got[i].Seq, want[i].ScopeID, want[i].Seq)
+		}
+	}
+
+	for i := 1; i < len(got); i++ {
+		a, b := got[i-1], got[i]
+		if a.ScopeID == b.ScopeID && a.Key == b.Key && !a.IsScope && a.Seq > b.Seq {
+			t.Fatalf("entry %d: writes of %s.%s out of order", i, b.ScopeID, b.Key)
+		}
+	}
+}
+
+func TestSorter(t *testing.T) {
+	tests := []struct {
+		name string
+		// runs is the number of runs spilled to disk, 0 to sort in memory.
+		runs int
+	}{
+		{name: "memory", runs: 0},
+		{name: "one run", runs: 1},
+		{name: "several runs", runs: 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shuffled, want := sortEntries(20, 5, 4)
+
+			s := newSorter(t.TempDir())
+
+			chunk := len(shuffled)
+			if tt.runs > 0 {
+				chunk = (len(shuffled) + tt.runs - 1) / tt.runs
+			}
+
+			for i, e := range shuffled {
+				if err := s.add(e); err != nil {
+					t.Fatalf("add: %v", err)
+				}
+
+				if tt.runs > 0 && (i+1)%chunk == 0 {
+					if err := s.spill(); err != nil {
+						t.Fatalf("spill: %v", err)
+					}
+				}
+			}
+
+			got := collect(t, s)
+			checkOrder(t, got, want)
+
+			if len(s.runs) != tt.runs {
+				t.Errorf("got %d runs, want %d", len(s.runs), tt.runs)
+			}
+		})
+	}
+}
+
+func TestSorterSave(t *testing.T) {
+	dir := t.TempDir()
+	shuffled, want := sortEntries(10, 3, 3)
+	half := len(shuffled) / 2
+
+	s := newSorter(dir)
+
+	for _, e := range shuffled[:half] {
+		if err := s.add(e); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+
+	if err := s.spill(); err != nil {
+		t.Fatalf("spill: %v", err)
+	}
+
+	saved := filepath.Join(dir, "saved")
+	if err := s.save(saved); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// A sorter resumed from the saved run merges the new entries with it.
+	s = newSorter(dir, saved)
+
+	for _, e := range shuffled[half:] {
+		if err := s.add(e); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+
+	checkOrder(t, collect(t, s), want)
+}
+
+func TestSpill(t *testing.T) {
+	s, err := newSpill(t.TempDir(), "spill-*")
+	if err != nil {
+		t.Fatalf("newSpill: %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if err := s.write(&entry{ScopeID: fmt.Sprint(i), Seq: uint64(i)}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	if err := s.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// Spills are read back any number of times.
+	for pass := 0; pass < 2; pass++ {
+		var n uint64
+
+		err := s.each(func(dec *gob.Decoder) error {
+			e := &entry{}
+			if err := dec.Decode(e); err != nil {
+				return err
+			}
+
+			if e.Seq != n {
+				t.Fatalf("pass %d: got entry %d, want %d", pass, e.Seq, n)
+			}
+
+			n++
+
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("each: %v", err)
+		}
+
+		if n != 1000 {
+			t.Errorf("pass %d: got %d entries, want 1000", pass, n)
+		}
+	}
+}
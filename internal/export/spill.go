@@ -0,0 +1,77 @@
+package export
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// spill is a temporary file of gob encoded values, written once then read
+// back any number of times.
+type spill struct {
+	path string
+	file *os.File
+	w    *bufio.Writer
+	enc  *gob.Encoder
+}
+
+func newSpill(dir, pattern string) (*spill, error) {
+	file, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "create temporary file")
+	}
+
+	w := bufio.NewWriter(file)
+
+	return &spill{
+		path: file.Name(),
+		file: file,
+		w:    w,
+		enc:  gob.NewEncoder(w),
+	}, nil
+}
+
+func (s *spill) write(v interface{}) error {
+	return errors.Wrap(s.enc.Encode(v), "write temporary file")
+}
+
+// close must be called once all values are written, before reading.
+func (s *spill) close() error {
+	if s.file == nil {
+		return nil
+	}
+
+	if err := s.w.Flush(); err != nil {
+		return errors.Wrap(err, "write temporary file")
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	return errors.Wrap(err, "close temporary file")
+}
+
+// each calls next until all values have been decoded. next must decode a
+// single value and return the decoding error as is.
+func (s *spill) each(next func(dec *gob.Decoder) error) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return errors.Wrap(err, "open temporary file")
+	}
+	defer file.Close()
+
+	dec := gob.NewDecoder(bufio.NewReader(file))
+
+	for {
+		if err := next(dec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package export
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	// SQLite driver, without cgo.
+	_ "modernc.org/sqlite"
+)
+
+// sqliteHistoryTable is the name of the table holding every attribute change,
+// and sqliteManifestTable of the table holding the manifest.
+const (
+	sqliteHistoryTable  = "attributeHistory"
+	sqliteManifestTable = "manifest"
+)
+
+var sqliteFormat = &Format{
+	Name:      FormatSQLite,
+	Ext:       "db",
+	History:   true,
+	NewWriter: newSQLiteWriter,
+}
+
+// ExportSQLite exports the data of the tajriba file to a SQLite database,
+// with a table per kind of Scope and a table with the history of attribute
+// changes of all kinds. Columns are typed from the values of each key.
+func ExportSQLite(tajfile, filename string, opts *Options) error {
+	return exportFormat(tajfile, filename, opts, sqliteFormat)
+}
+
+// sqliteWriter is the Writer of SQLite databases.
+type sqliteWriter struct {
+	db *sql.DB
+
+	// names are the names of the tables and indexes of the database.
+	names sqliteNames
+
+	// tables are the kind tables written and changes the number of rows of
+	// the history table, for the manifest.
+	tables  []*FileInfo
+	changes int
+}
+
+func newSQLiteWriter(filename string, opts *Options) (Writer, error) {
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "remove existing database")
+	}
+
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "open database")
+	}
+
+	for _, pragma := range []string{
+		"PRAGMA journal_mode = OFF",
+		"PRAGMA synchronous = OFF",
+	} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+
+			return nil, errors.Wrap(err, "configure database")
+		}
+	}
+
+	var source string
+	if len(opts.Merge) > 0 {
+		source = `,
+		"source" TEXT`
+	}
+
+	_, err = db.Exec(`CREATE TABLE ` + sqliteIdent(sqliteHistoryTable) + ` (
+		"kind" TEXT NOT NULL,
+		"scopeID" TEXT NOT NULL,
+		"key" TEXT NOT NULL,
+		"value" TEXT,
+		"index" INTEGER,
+		"createdAt" TEXT,
+		"creatorID" TEXT` + source + `
+	)`)
+	if err != nil {
+		db.Close()
+
+		return nil, errors.Wrap(err, "create history table")
+	}
+
+	names := sqliteNames{}
+	names.add(sqliteHistoryTable)
+	names.add(sqliteManifestTable)
+
+	return &sqliteWriter{db: db, names: names}, nil
+}
+
+func (w *sqliteWriter) WriteKind(kind *Kind) error {
+	table := w.names.add(camelCase(kind.Name))
+	if table != camelCase(kind.Name) {
+		log.Warn().
+			Str("kind", kind.Name).
+			Str("table", table).
+			Msg("export: table name already used, renamed")
+	}
+
+	if err := writeKindSQLite(w.db, w.names, kind, table); err != nil {
+		return err
+	}
+
+	w.tables = append(w.tables, &FileInfo{Name: table, Rows: kind.Count})
+
+	n, err := writeHistorySQLite(w.db, kind, table)
+	w.changes += n
+
+	return err
+}
+
+// WriteManifest writes the manifest, as JSON, in the single row of the
+// manifest table, with the tables of the database as files.
+func (w *sqliteWriter) WriteManifest(m *Manifest) error {
+	m.Files = append(m.Files, w.tables...)
+	m.Files = append(m.Files, &FileInfo{Name: sqliteHistoryTable, Rows: w.changes})
+
+	var buf bytes.Buffer
+
+	if err := writeManifest(&buf, m); err != nil {
+		return err
+	}
+
+	table := sqliteIdent(sqliteManifestTable)
+
+	if _, err := w.db.Exec(`CREATE TABLE ` + table + ` ("manifest" TEXT NOT NULL)`); err != nil {
+		return errors.Wrap(err, "create manifest table")
+	}
+
+	_, err := w.db.Exec(`INSERT INTO `+table+` VALUES (?)`, buf.String())
+
+	return errors.Wrap(err, "insert into manifest")
+}
+
+func (w *sqliteWriter) Close() error {
+	for _, columns := range []string{"scopeID", "createdAt", "kind, key"} {
+		if err := createSQLiteIndex(w.db, w.names, sqliteHistoryTable, columns); err != nil {
+			w.db.Close()
+
+			return err
+		}
+	}
+
+	return errors.Wrap(w.db.Close(), "close database")
+}
+
+func sqliteType(t *keyType) string {
+	if t.vector {
+		return "TEXT"
+	}
+
+	switch t.typ {
+	case typeBool, typeInt:
+		return "INTEGER"
+	case typeFloat:
+		return "REAL"
+	default:
+		return "TEXT"
+	}
+}
+
+// sqliteValue converts the value of an attribute to the column type. Vectors
+// are stored as JSON arrays.
+func sqliteValue(t *keyType, attribute *Attribute) interface{} {
+	if t.vector {
+		var buf bytes.Buffer
+
+		writeJSONValue(&buf, attribute)
+
+		return buf.String()
+	}
+
+	return goValue(t.typ, attribute.Value)
+}
+
+// writeKindSQLite writes the scopes of the kind to table. Keys with the same
+// name as another column, ignoring case, such as the id column, relations, or
+// the LastChangedAt column of another key, are renamed, see sqliteNames.
+func writeKindSQLite(db *sql.DB, names sqliteNames, kind *Kind, table string) error {
+	types, err := keyTypes(kind)
+	if err != nil {
+		return err
+	}
+
+	cols := sqliteNames{}
+
+	column := func(name string) string {
+		col := cols.add(name)
+		if col != name {
+			log.Warn().
+				Str("table", table).
+				Str("column", col).
+				Msgf("export: column %s already used, renamed", name)
+		}
+
+		return col
+	}
+
+	columns := []string{sqliteIdent(column("id"))}
+	defs := []string{sqliteIdent("id") + " TEXT PRIMARY KEY"}
+
+	if kind.Merged {
+		columns = append(columns, sqliteIdent(column("source")))
+		defs = append(defs, sqliteIdent("source")+" TEXT")
+	}
+
+	relations := make([]string, len(kind.Relations))
+
+	for i, key := range kind.Relations {
+		relations[i] = column(key)
+		columns = append(columns, sqliteIdent(relations[i]))
+		defs = append(defs, sqliteIdent(relations[i])+" TEXT")
+	}
+
+	for _, key := range kind.Keys {
+		col, changed := column(key), column(key+"LastChangedAt")
+
+		columns = append(columns, sqliteIdent(col), sqliteIdent(changed))
+		defs = append(defs,
+			sqliteIdent(col)+" "+sqliteType(types[key]),
+			sqliteIdent(changed)+" TEXT",
+		)
+	}
+
+	_, err = db.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", sqliteIdent(table), strings.Join(defs, ", ")))
+	if err != nil {
+		return errors.Wrapf(err, "create %s table", table)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		sqliteIdent(table),
+		strings.Join(columns, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "),
+	)
+
+	err = sqliteInsert(db, query, func(stmt *sql.Stmt) error {
+		return kind.EachScope(func(scope *Scope) error {
+			values := []interface{}{scope.ID}
+			if kind.Merged {
+				values = append(values, scope.Source)
+			}
+
+			for _, key := range kind.Relations {
+				values = append(values, decodeValue(scope.relationValue(key)))
+			}
+
+			for _, key := range kind.Keys {
+				attribute, ok := scope.Attributes[key]
+				if !ok {
+					values = append(values, nil, nil)
+
+					continue
+				}
+
+				values = append(values, sqliteValue(types[key], attribute), attribute.Last)
+			}
+
+			_, err := stmt.Exec(values...)
+
+			return errors.Wrapf(err, "insert into %s", table)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, col := range relations {
+		if err := createSQLiteIndex(db, names, table, col); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeHistorySQLite inserts the changes of the kind in the history table and
+// returns the number of changes.
+func writeHistorySQLite(db *sql.DB, kind *Kind, table string) (int, error) {
+	params := "?, ?, ?, ?, ?, ?, ?"
+	if kind.Merged {
+		params += ", ?"
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s VALUES (%s)`, sqliteIdent(sqliteHistoryTable), params)
+
+	var n int
+
+	err := sqliteInsert(db, query, func(stmt *sql.Stmt) error {
+		return kind.EachChange(func(change *Change) error {
+			var index interface{}
+			if change.IsVector {
+				index = change.Index
+			}
+
+			values := []interface{}{
+				table,
+				change.ScopeID,
+				change.Key,
+				jsonValue(change.Value),
+				index,
+				change.CreatedAt,
+				change.CreatorID,
+			}
+			if kind.Merged {
+				values = append(values, change.Source)
+			}
+
+			n++
+
+			_, err := stmt.Exec(values...)
+
+			return errors.Wrap(err, "insert into history")
+		})
+	})
+
+	return n, err
+}
+
+// sqliteInsert runs insert in a transaction with query prepared.
+func sqliteInsert(db *sql.DB, query string, insert func(stmt *sql.Stmt) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		_ = tx.Rollback()
+
+		return errors.Wrap(err, "prepare insert")
+	}
+
+	if err := insert(stmt); err != nil {
+		_ = stmt.Close()
+		_ = tx.Rollback()
+
+		return err
+	}
+
+	if err := stmt.Close(); err != nil {
+		_ = tx.Rollback()
+
+		return errors.Wrap(err, "close insert")
+	}
+
+	return errors.Wrap(tx.Commit(), "commit transaction")
+}
+
+func createSQLiteIndex(db *sql.DB, names sqliteNames, table, columns string) error {
+	cols := strings.Split(columns, ", ")
+	name := names.add(table + "_" + strings.Join(cols, "_"))
+
+	for i, col := range cols {
+		cols[i] = sqliteIdent(col)
+	}
+
+	_, err := db.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (%s)",
+		sqliteIdent(name), sqliteIdent(table), strings.Join(cols, ", ")))
+
+	return errors.Wrapf(err, "create index %s", name)
+}
+
+// sqliteNames are the names used in a namespace of the database: the tables
+// and indexes, or the columns of a table. SQLite compares names ignoring case.
+type sqliteNames map[string]bool
+
+// add returns name, with a _2, _3, ... suffix if it is already used, and marks
+// it as used.
+func (n sqliteNames) add(name string) string {
+	unique := name
+
+	for i := 2; n[strings.ToLower(unique)]; i++ {
+		unique = fmt.Sprintf("%s_%d", name, i)
+	}
+
+	n[strings.ToLower(unique)] = true
+
+	return unique
+}
+
+// sqliteIdent quotes an SQL identifier.
+func sqliteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
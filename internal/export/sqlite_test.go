@@ -0,0 +1,120 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sqliteColumns(t *testing.T, db *sql.DB, table string) []string {
+	t.Helper()
+
+	rows, err := db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info(%s)", sqliteIdent(table)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var cols []string
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+
+		cols = append(cols, name)
+	}
+
+	return cols
+}
+
+func TestSQLiteNameCollisions(t *testing.T) {
+	tajfile := writeTajfile(t,
+		[][2]string{{"s1", "game"}, {"s2", "Game"}, {"s3", "manifest"}, {"s4", "attributeHistory"}},
+		[][3]string{
+			{"s1", "color", `"red"`},
+			{"s1", "Color", `"blue"`},
+			{"s1", "colorLastChangedAt", `1`},
+			{"s1", "ID", `2`},
+			{"s3", "value", `3`},
+		},
+	)
+
+	filename := filepath.Join(t.TempDir(), "export.db")
+	if err := Export(tajfile, filename, &Options{Format: FormatSQLite}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tables []string
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+
+		tables = append(tables, name)
+	}
+
+	rows.Close()
+	sort.Strings(tables)
+
+	// Kind names are camel cased, Game is a second game table.
+	want := "attributeHistory,attributeHistory_2,game,game_2,manifest,manifest_2"
+	if got := strings.Join(tables, ","); got != want {
+		t.Errorf("got tables %s, want %s", got, want)
+	}
+
+	// Every column of a table is unique, ignoring case.
+	for _, table := range tables {
+		seen := make(map[string]bool)
+
+		for _, col := range sqliteColumns(t, db, table) {
+			if seen[strings.ToLower(col)] {
+				t.Errorf("table %s: duplicate column %s", table, col)
+			}
+
+			seen[strings.ToLower(col)] = true
+		}
+	}
+
+	var manifests int
+	if err := db.QueryRow(`SELECT count(*) FROM "manifest"`).Scan(&manifests); err != nil {
+		t.Fatal(err)
+	}
+
+	if manifests != 1 {
+		t.Errorf("got %d rows in the manifest table, want 1", manifests)
+	}
+}
+
+func TestSQLiteNames(t *testing.T) {
+	n := sqliteNames{}
+
+	for _, tt := range []struct{ name, want string }{
+		{"color", "color"},
+		{"Color", "Color_2"},
+		{"COLOR", "COLOR_3"},
+		{"color_2", "color_2_2"},
+		{"other", "other"},
+	} {
+		if got := n.add(tt.name); got != tt.want {
+			t.Errorf("add(%s): got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// valueType is the type of JSON values.
+type valueType int
+
+const (
+	typeNull valueType = iota
+	typeBool
+	typeInt
+	typeFloat
+	typeString
+	typeJSON
+)
+
+// merge returns a type that can hold values of both types.
+func (t valueType) merge(o valueType) valueType {
+	switch {
+	case t == o || o == typeNull:
+		return t
+	case t == typeNull:
+		return o
+	case (t == typeInt && o == typeFloat) || (t == typeFloat && o == typeInt):
+		return typeFloat
+	default:
+		return typeJSON
+	}
+}
+
+// decodeValue decodes a JSON encoded value, keeping numbers as json.Number.
+// Invalid JSON is returned as a string.
+func decodeValue(val string) interface{} {
+	if val == "" {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader([]byte(val)))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return val
+	}
+
+	return v
+}
+
+func typeOf(v interface{}) valueType {
+	switch t := v.(type) {
+	case nil:
+		return typeNull
+	case bool:
+		return typeBool
+	case json.Number:
+		if _, err := t.Int64(); err == nil {
+			return typeInt
+		}
+
+		return typeFloat
+	case string:
+		return typeString
+	default:
+		return typeJSON
+	}
+}
+
+// keyType is the type of the values of a key across all Scopes of a kind.
+type keyType struct {
+	typ    valueType
+	vector bool
+	scalar bool
+}
+
+// value converts the value of an attribute to the Go type of the key.
+// Vectors are converted to slices, unless the key is both a vector and a
+// scalar, then the value is JSON.
+func (k *keyType) value(attribute *Attribute) interface{} {
+	if k.vector && k.scalar {
+		var buf bytes.Buffer
+
+		writeJSONValue(&buf, attribute)
+
+		return buf.String()
+	}
+
+	if !attribute.IsVector {
+		return goValue(k.typ, attribute.Value)
+	}
+
+	values := make([]interface{}, len(attribute.Values))
+	for i, v := range attribute.Values {
+		if k.typ == typeJSON {
+			values[i] = jsonValue(v)
+		} else {
+			values[i] = goValue(k.typ, v)
+		}
+	}
+
+	return values
+}
+
+// goValue converts a JSON encoded value to the Go type corresponding to typ.
+func goValue(typ valueType, val string) interface{} {
+	v := decodeValue(val)
+	if v == nil {
+		return nil
+	}
+
+	switch typ {
+	case typeBool:
+		return v
+	case typeInt:
+		i, _ := v.(json.Number).Int64()
+
+		return i
+	case typeFloat:
+		f, _ := v.(json.Number).Float64()
+
+		return f
+	case typeString:
+		return v
+	default:
+		return jsonValue(val)
+	}
+}
+
+// jsonValue returns the value as compact JSON, null values included.
+func jsonValue(val string) string {
+	var buf bytes.Buffer
+
+	writeJSONRaw(&buf, val)
+
+	return buf.String()
+}
+
+// parseTime parses a tajriba timestamp, returning nil if invalid.
+func parseTime(s string) interface{} {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil
+	}
+
+	return t
+}
+
+// keyTypes infers the type of the values of each key of the kind.
+func keyTypes(kind *Kind) (map[string]*keyType, error) {
+	types := make(map[string]*keyType, len(kind.Keys))
+	for _, key := range kind.Keys {
+		types[key] = &keyType{}
+	}
+
+	err := kind.EachScope(func(scope *Scope) error {
+		for key, attribute := range scope.Attributes {
+			t, ok := types[key]
+			if !ok {
+				continue
+			}
+
+			if attribute.IsVector {
+				t.vector = true
+
+				for _, v := range attribute.Values {
+					vt := typeOf(decodeValue(v))
+					if vt == typeNull {
+						vt = typeJSON
+					}
+
+					t.typ = t.typ.merge(vt)
+				}
+			} else {
+				t.scalar = true
+				t.typ = t.typ.merge(typeOf(decodeValue(attribute.Value)))
+			}
+		}
+
+		return nil
+	})
+
+	return types, err
+}
@@ -0,0 +1,143 @@
+package export
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// Writer writes the data of an export in a format. WriteKind is called with
+// every kind of Scope, in order, then WriteManifest if the Writer is a
+// ManifestWriter, then Close is called, even if writing failed.
+type Writer interface {
+	// WriteKind writes the Scopes of the kind, read with Kind.EachScope, and
+	// the attribute changes, read with Kind.EachChange, if the History option
+	// is set.
+	WriteKind(kind *Kind) error
+
+	// Close completes the export and releases the resources of the Writer.
+	Close() error
+}
+
+// Format is an export format, see RegisterFormat.
+type Format struct {
+	// Name is the name of the format, set in Options.Format.
+	Name string
+
+	// Ext is the extension of the exported file, without dot.
+	Ext string
+
+	// History sets the History option, for formats always exporting the
+	// changes of attributes.
+	History bool
+
+	// NewWriter creates the Writer of an export to the file, with the options
+	// of the export.
+	NewWriter func(filename string, opts *Options) (Writer, error)
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]*Format{
+		FormatCSV:     csvFormat,
+		FormatJSONL:   jsonlFormat,
+		FormatParquet: parquetFormat,
+		FormatSQLite:  sqliteFormat,
+	}
+)
+
+// RegisterFormat makes an export format available to Export, by name. It
+// panics if the format is incomplete or a format with the same name is
+// already registered, and should be called from an init function.
+func RegisterFormat(format *Format) {
+	if format == nil || format.Name == "" || format.NewWriter == nil {
+		panic("export: incomplete format")
+	}
+
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	if _, ok := formats[format.Name]; ok {
+		panic("export: format already registered: " + format.Name)
+	}
+
+	formats[format.Name] = format
+}
+
+// LookupFormat returns the registered format with the name, csv if empty.
+func LookupFormat(name string) (*Format, bool) {
+	if name == "" {
+		name = FormatCSV
+	}
+
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	format, ok := formats[name]
+
+	return format, ok
+}
+
+// Formats returns the names of the registered formats, sorted.
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// exportFormat prepares the tajriba file and writes every kind with a Writer
+// of the format.
+func exportFormat(tajfile, filename string, opts *Options, format *Format) error {
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+
+	if format.History {
+		o.History = true
+	}
+
+	data, err := prepare(tajfile, &o)
+	if err != nil {
+		return errors.Wrap(err, "prepare export")
+	}
+	defer data.Close()
+
+	w, err := format.NewWriter(filename, &o)
+	if err != nil {
+		return errors.Wrapf(err, "create %s writer", format.Name)
+	}
+
+	for _, kind := range data.Kinds {
+		log.Info().
+			Int("count", kind.Count).
+			Int("keys", len(kind.Keys)).
+			Msgf("Exporting %s", kind.Name)
+
+		if err := w.WriteKind(kind); err != nil {
+			_ = w.Close()
+
+			return err
+		}
+	}
+
+	if mw, ok := w.(ManifestWriter); ok {
+		if err := mw.WriteManifest(newManifest(data, format)); err != nil {
+			_ = w.Close()
+
+			return err
+		}
+	}
+
+	return errors.Wrap(w.Close(), "close export")
+}
@@ -0,0 +1,158 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// linesWriter is a Writer of a custom format, writing a line per kind, Scope
+// and change to the file.
+type linesWriter struct {
+	file *os.File
+}
+
+func (w *linesWriter) WriteKind(kind *Kind) error {
+	if _, err := fmt.Fprintf(w.file, "kind %s %d\n", kind.Name, kind.Count); err != nil {
+		return err
+	}
+
+	err := kind.EachScope(func(scope *Scope) error {
+		_, err := fmt.Fprintf(w.file, "scope %s %d\n", scope.ID, len(scope.Attributes))
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if !kind.HasHistory() {
+		return nil
+	}
+
+	return kind.EachChange(func(change *Change) error {
+		_, err := fmt.Fprintf(w.file, "change %s %s %s\n", change.ScopeID, change.Key, change.Value)
+
+		return err
+	})
+}
+
+func (w *linesWriter) WriteManifest(m *Manifest) error {
+	_, err := fmt.Fprintf(w.file, "manifest %s\n", m.Format)
+
+	return err
+}
+
+func (w *linesWriter) Close() error {
+	return w.file.Close()
+}
+
+var linesFormat = &Format{
+	Name:    "lines",
+	Ext:     "txt",
+	History: true,
+	NewWriter: func(filename string, _ *Options) (Writer, error) {
+		file, err := os.Create(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		return &linesWriter{file: file}, nil
+	},
+}
+
+func init() {
+	RegisterFormat(linesFormat)
+}
+
+func TestCustomFormat(t *testing.T) {
+	tajfile := writeTajfile(t,
+		[][2]string{{"g1", "game"}, {"p1", "player"}},
+		[][3]string{
+			{"g1", "status", `"running"`},
+			{"g1", "status", `"ended"`},
+			{"p1", "score", `1`},
+		})
+
+	filename := filepath.Join(t.TempDir(), "export.txt")
+	if err := Export(tajfile, filename, &Options{Format: "lines"}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"kind game 1",
+		"scope g1 1",
+		`change g1 status "running"`,
+		`change g1 status "ended"`,
+		"kind player 1",
+		"scope p1 1",
+		"change p1 score 1",
+		"manifest lines",
+	}
+
+	if got := strings.Split(strings.TrimSpace(string(b)), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got lines\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLookupFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		want *Format
+	}{
+		{"", csvFormat},
+		{FormatCSV, csvFormat},
+		{FormatJSONL, jsonlFormat},
+		{FormatParquet, parquetFormat},
+		{FormatSQLite, sqliteFormat},
+		{"lines", linesFormat},
+		{"xml", nil},
+	}
+
+	for _, tt := range tests {
+		format, ok := LookupFormat(tt.name)
+		if format != tt.want || ok != (tt.want != nil) {
+			t.Errorf("got format %v (%v) for %q, want %v", format, ok, tt.name, tt.want)
+		}
+	}
+
+	want := []string{FormatCSV, FormatJSONL, "lines", FormatParquet, FormatSQLite}
+	if got := Formats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got formats %v, want %v", got, want)
+	}
+}
+
+func TestRegisterFormatPanics(t *testing.T) {
+	newWriter := linesFormat.NewWriter
+
+	tests := []struct {
+		name   string
+		format *Format
+		want   string
+	}{
+		{"nil", nil, "export: incomplete format"},
+		{"without name", &Format{NewWriter: newWriter}, "export: incomplete format"},
+		{"without writer", &Format{Name: "other"}, "export: incomplete format"},
+		{"registered", &Format{Name: FormatCSV, NewWriter: newWriter}, "export: format already registered: csv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != tt.want {
+					t.Errorf("got panic %v, want %q", r, tt.want)
+				}
+			}()
+
+			RegisterFormat(tt.format)
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package export
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+const fileDefaultPerms = 0o644
+
+// Export exports the data of the tajriba file in the format given in the
+// options: a zip file of CSV, JSON Lines or Parquet files, a SQLite database,
+// or a format added with RegisterFormat.
+func Export(tajfile, filename string, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	switch opts.Vectors {
+	case "", VectorsJoined:
+	case VectorsLong, VectorsWide:
+		if opts.Format != "" && opts.Format != FormatCSV {
+			return errors.New("vector expansion is only supported in csv exports")
+		}
+	default:
+		return errors.Errorf("unknown vector expansion: %s", opts.Vectors)
+	}
+
+	format, ok := LookupFormat(opts.Format)
+	if !ok {
+		return errors.Errorf("unknown export format: %s", opts.Format)
+	}
+
+	return exportFormat(tajfile, filename, opts, format)
+}
+
+// ZipFunc writes the files of a kind in a zip file.
+type ZipFunc func(z *Zip, kind *Kind) error
+
+// Zip is a zip file being written by a ZipFunc. The size, SHA-256 and number
+// of rows of its files are listed in the manifest of the export.
+type Zip struct {
+	z     *zip.Writer
+	files []*ZipFile
+}
+
+// Create adds a file to the zip file, see zip.Writer.Create. The file must be
+// written before the next one is created.
+func (z *Zip) Create(name string) (*ZipFile, error) {
+	w, err := z.z.Create(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "create file in zip")
+	}
+
+	f := &ZipFile{Name: name, w: w, hash: sha256.New()}
+	z.files = append(z.files, f)
+
+	return f, nil
+}
+
+// ZipFile is a file of a Zip. Rows is the number of rows, or records, of the
+// file, incremented by the ZipFunc for the manifest.
+type ZipFile struct {
+	Name string
+	Rows int
+
+	w    io.Writer
+	size int64
+	hash hash.Hash
+}
+
+func (f *ZipFile) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.size += int64(n)
+	f.hash.Write(p[:n])
+
+	return n, err
+}
+
+// zipWriter is a Writer of a zip file, with the files written by a ZipFunc,
+// the codebook of each kind and the manifest of the export.
+type zipWriter struct {
+	file  *os.File
+	z     *Zip
+	write ZipFunc
+}
+
+// NewZipWriter returns a Writer creating a zip file, for formats with a file
+// per kind. write is called with every kind, the codebook of the kind is
+// written after it.
+func NewZipWriter(filename string, write ZipFunc) (Writer, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fileDefaultPerms)
+	if err != nil {
+		return nil, errors.Wrap(err, "open file")
+	}
+
+	return &zipWriter{
+		file:  file,
+		z:     &Zip{z: zip.NewWriter(file)},
+		write: write,
+	}, nil
+}
+
+func (w *zipWriter) WriteKind(kind *Kind) error {
+	if err := w.write(w.z, kind); err != nil {
+		return err
+	}
+
+	return writeCodebook(w.z, kind)
+}
+
+// WriteManifest writes the manifest in the manifest.json file, with the files
+// of the zip file.
+func (w *zipWriter) WriteManifest(m *Manifest) error {
+	for _, f := range w.z.files {
+		m.Files = append(m.Files, &FileInfo{
+			Name:   f.Name,
+			Rows:   f.Rows,
+			Size:   f.size,
+			SHA256: hex.EncodeToString(f.hash.Sum(nil)),
+		})
+	}
+
+	zf, err := w.z.z.Create(manifestFile)
+	if err != nil {
+		return errors.Wrap(err, "create file in zip")
+	}
+
+	return writeManifest(zf, m)
+}
+
+func (w *zipWriter) Close() error {
+	if err := w.z.z.Close(); err != nil {
+		w.file.Close()
+
+		return errors.Wrap(err, "close zip")
+	}
+
+	return errors.Wrap(w.file.Close(), "close file")
+}
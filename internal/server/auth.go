@@ -0,0 +1,290 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog/log"
+)
+
+// authQuery is the GraphQL query used to verify session tokens. Only admin
+// sessions (admin users and services) can query scopes.
+const authQuery = `{"query":"{ scopes(first: 1) { totalCount } }"}`
+
+// Auth protects the admin endpoints of the server (treatments and lobbies).
+// In production, requests must carry a tajriba admin session token, or the
+// service registration token, as a Bearer token in the Authorization header.
+// Endpoints are open in development, as tajriba accepts a development admin
+// token.
+type Auth struct {
+	production bool
+	token      string
+	graphql    http.Handler
+}
+
+// NewAuth returns the Auth of the server. graphql is the handler of the
+// tajriba GraphQL endpoint (/query), used to verify session tokens.
+func NewAuth(config *Config, graphql http.Handler) *Auth {
+	return &Auth{
+		production: config.Production,
+		token:      config.Token,
+		graphql:    graphql,
+	}
+}
+
+// Protect returns a handler calling h only for authorized requests. It
+// responds with 401 Unauthorized if the request has no token, and with 403
+// Forbidden if the token is not accepted.
+func (a *Auth) Protect(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if !a.production {
+			h(w, r.WithContext(withAuthor(r.Context(), "development")), ps)
+
+			return
+		}
+
+		token := bearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="empirica"`)
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		author, ok := a.authorize(r, token)
+		if !ok {
+			log.Warn().
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Msg("server: unauthorized admin request")
+
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		h(w, r.WithContext(withAuthor(r.Context(), author)), ps)
+	}
+}
+
+// authorize returns the author of the request if the token is accepted.
+func (a *Auth) authorize(r *http.Request, token string) (string, bool) {
+	if a.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1 {
+		return "service", true
+	}
+
+	if a.graphql == nil || !a.checkSession(r, token) {
+		return "", false
+	}
+
+	if username, ok := sessions.user(token); ok {
+		return username, true
+	}
+
+	return unknownAdmin, true
+}
+
+// unknownAdmin is the author of the requests of admin sessions the server did
+// not see log in, e.g. before it restarted.
+const unknownAdmin = "admin"
+
+// sessionUsers are the usernames of the admin sessions, by hash of their
+// token, recorded by RecordLogins. Tajriba does not return the user of a
+// session token.
+type sessionUsers struct {
+	mu    sync.Mutex
+	users map[string]string
+}
+
+var sessions = &sessionUsers{users: make(map[string]string)}
+
+func sessionKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *sessionUsers) add(token, username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[sessionKey(token)] = username
+}
+
+func (s *sessionUsers) user(token string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	username, ok := s.users[sessionKey(token)]
+
+	return username, ok
+}
+
+// loginRequest is the part of a tajriba login mutation needed to identify the
+// session it creates.
+type loginRequest struct {
+	Query     string `json:"query"`
+	Variables struct {
+		Input struct {
+			Username string `json:"username"`
+		} `json:"input"`
+	} `json:"variables"`
+}
+
+// RecordLogins returns a handler calling h, which records the username of the
+// admin sessions created by the login mutations sent to the tajriba GraphQL
+// endpoint (/query), so Auth can identify the author of admin requests.
+func RecordLogins(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/query" || r.Body == nil {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var req loginRequest
+		if json.Unmarshal(body, &req) != nil || req.Variables.Input.Username == "" ||
+			!strings.Contains(req.Query, "login") {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(rec, r)
+
+		var resp struct {
+			Data struct {
+				Login struct {
+					SessionToken string `json:"sessionToken"`
+				} `json:"login"`
+			} `json:"data"`
+		}
+
+		if rec.code != http.StatusOK || json.Unmarshal(rec.body.Bytes(), &resp) != nil {
+			return
+		}
+
+		if token := resp.Data.Login.SessionToken; token != "" {
+			sessions.add(token, req.Variables.Input.Username)
+		}
+	})
+}
+
+// responseRecorder is an http.ResponseWriter keeping a copy of the response.
+type responseRecorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.body.Write(p)
+
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// checkSession returns true if the token is a tajriba admin session token.
+func (a *Auth) checkSession(r *http.Request, token string) bool {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, "/query", strings.NewReader(authQuery))
+	if err != nil {
+		log.Error().Err(err).Msg("server: create session check request")
+
+		return false
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res := newResponseBuffer()
+	a.graphql.ServeHTTP(res, req)
+
+	if res.code != http.StatusOK {
+		return false
+	}
+
+	var resp struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []interface{}          `json:"errors"`
+	}
+
+	if err := json.Unmarshal(res.body.Bytes(), &resp); err != nil {
+		return false
+	}
+
+	return len(resp.Errors) == 0 && resp.Data["scopes"] != nil
+}
+
+type authorKey struct{}
+
+func withAuthor(ctx context.Context, author string) context.Context {
+	return context.WithValue(ctx, authorKey{}, author)
+}
+
+// Author returns the author of a request authorized by Auth: "service" for
+// the service registration token, the username of admin sessions ("admin" if
+// unknown, see RecordLogins), or "development" in development.
+func Author(ctx context.Context) string {
+	author, _ := ctx.Value(authorKey{}).(string)
+
+	return author
+}
+
+// bearerToken returns the Bearer token of the request, if any.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+
+	const prefix = "bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return ""
+	}
+
+	return strings.TrimSpace(auth[len(prefix):])
+}
+
+// responseBuffer is an http.ResponseWriter keeping the response in memory.
+type responseBuffer struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header), code: http.StatusOK}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *responseBuffer) WriteHeader(code int) {
+	b.code = code
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// fakeTajriba is a tajriba GraphQL endpoint accepting the admin session
+// tokens of admins, created by logging in, and oldAdmin. Participant sessions
+// cannot query scopes.
+func fakeTajriba(admins map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		if strings.Contains(string(body), "login") {
+			for token, username := range admins {
+				if strings.Contains(string(body), `"username":"`+username+`"`) {
+					_, _ = io.WriteString(w, `{"data":{"login":{"sessionToken":"`+token+`"}}}`)
+
+					return
+				}
+			}
+
+			_, _ = io.WriteString(w, `{"errors":[{"message":"invalid credentials"}],"data":null}`)
+
+			return
+		}
+
+		token := bearerToken(r)
+
+		switch {
+		case admins[token] != "" || token == "oldAdmin":
+			_, _ = io.WriteString(w, `{"data":{"scopes":{"totalCount":1}}}`)
+		case token == "participant":
+			_, _ = io.WriteString(w, `{"errors":[{"message":"not allowed"}],"data":null}`)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+}
+
+// login logs the admin in through RecordLogins.
+func login(t *testing.T, graphql http.Handler, username string) {
+	t.Helper()
+
+	body := `{"query":"mutation Login($input: LoginInput!) { login(input: $input) { sessionToken } }",` +
+		`"variables":{"input":{"username":"` + username + `","password":"secret"}}}`
+
+	w := httptest.NewRecorder()
+	RecordLogins(graphql).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: got status %d", w.Code)
+	}
+}
+
+func TestProtect(t *testing.T) {
+	graphql := fakeTajriba(map[string]string{"annSession": "ann", "bobSession": "bob"})
+
+	login(t, graphql, "ann")
+	login(t, graphql, "bob")
+	login(t, graphql, "eve")
+
+	tests := []struct {
+		name       string
+		production bool
+		auth       string
+		code       int
+		author     string
+	}{
+		{name: "development without token", code: http.StatusOK, author: "development"},
+		{name: "development with invalid token", auth: "Bearer invalid", code: http.StatusOK, author: "development"},
+		{name: "missing token", production: true, code: http.StatusUnauthorized},
+		{name: "not a bearer token", production: true, auth: "Basic YWRtaW46c2VjcmV0", code: http.StatusUnauthorized},
+		{name: "empty bearer token", production: true, auth: "Bearer ", code: http.StatusUnauthorized},
+		{name: "invalid token", production: true, auth: "Bearer invalid", code: http.StatusForbidden},
+		{name: "participant session", production: true, auth: "Bearer participant", code: http.StatusForbidden},
+		{name: "service token", production: true, auth: "Bearer service-token", code: http.StatusOK, author: "service"},
+		{name: "admin session", production: true, auth: "Bearer annSession", code: http.StatusOK, author: "ann"},
+		{name: "other admin session", production: true, auth: "bearer bobSession", code: http.StatusOK, author: "bob"},
+		{name: "admin session before login recorded", production: true, auth: "Bearer oldAdmin", code: http.StatusOK, author: unknownAdmin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := NewAuth(&Config{Production: tt.production, Token: "service-token"}, graphql)
+
+			var author string
+
+			h := auth.Protect(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+				author = Author(r.Context())
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/treatments", nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+
+			w := httptest.NewRecorder()
+			h(w, r, nil)
+
+			if w.Code != tt.code {
+				t.Errorf("got status %d, want %d", w.Code, tt.code)
+			}
+
+			if author != tt.author {
+				t.Errorf("got author %q, want %q", author, tt.author)
+			}
+
+			if tt.code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestRecordLoginsKeepsRequest(t *testing.T) {
+	var got string
+
+	h := RecordLogins(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+
+		_, _ = io.WriteString(w, `{"data":{"login":{"sessionToken":"tok"}}}`)
+	}))
+
+	body := `{"query":"mutation { login(input: $input) { sessionToken } }","variables":{"input":{"username":"ann"}}}`
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body)))
+
+	if got != body {
+		t.Errorf("got request body %q, want %q", got, body)
+	}
+
+	if w.Body.String() != `{"data":{"login":{"sessionToken":"tok"}}}` {
+		t.Errorf("got response %q", w.Body.String())
+	}
+
+	if username, ok := sessions.user("tok"); !ok || username != "ann" {
+		t.Errorf("got session user %q, want ann", username)
+	}
+}
@@ -18,6 +18,10 @@ type Config struct {
 	// Player frontend proxy
 	ProxyAddr string `mapstructure:"proxyaddr"`
 
+	// Token is the service registration token of tajriba, accepted on the
+	// admin endpoints, see Auth.
+	Token string `mapstructure:"-"`
+
 	Production bool `mapstructure:"-"`
 }
 
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/empiricaly/empirica/internal/treatments"
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// validate validates the configuration files. Invalid fields are named by
+// their JSON name, as sent to and received from the admin UI.
+var validate = newValidate()
+
+func newValidate() *validator.Validate {
+	v := validator.New()
+
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+
+		return name
+	})
+
+	return v
+}
+
+// checker is implemented by configuration files with checks beyond their
+// struct tags, see treatments.Treatments.Check.
+type checker interface {
+	Check() error
+}
+
+// defaulter is implemented by configuration files with default values, see
+// treatments.Treatments.SetDefaults. Defaults are set when the file is served,
+// the file is written as submitted.
+type defaulter interface {
+	SetDefaults()
+}
+
+// validateConfig validates the configuration file with its struct tags, and
+// its Check method, if any.
+func validateConfig(v interface{}) error {
+	if err := validate.Struct(v); err != nil {
+		return err
+	}
+
+	if c, ok := v.(checker); ok {
+		return c.Check()
+	}
+
+	return nil
+}
+
+// apiError is an error of the configuration file endpoints. Field is the path
+// of the invalid field, for validation errors, and Line its line in the YAML
+// file, if known.
+type apiError struct {
+	Field   string `json:"field,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// writeError responds with the error, as {"errors": [...]}, see apiErrors.
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, struct {
+		Errors []*apiError `json:"errors"`
+	}{
+		Errors: apiErrors(err),
+	})
+}
+
+// apiErrors returns the error as a list of apiErrors. Validation errors are
+// listed by field.
+func apiErrors(err error) []*apiError {
+	var errs []*apiError
+
+	var (
+		verrs  validator.ValidationErrors
+		issues treatments.Issues
+	)
+
+	if errors.As(err, &issues) {
+		for _, issue := range issues {
+			errs = append(errs, &apiError{
+				Field:   issue.Field,
+				Message: issue.Message,
+				Line:    issue.Line,
+			})
+		}
+	} else if errors.As(err, &verrs) {
+		for _, fe := range verrs {
+			errs = append(errs, &apiError{
+				Field:   fieldPath(fe),
+				Tag:     fe.Tag(),
+				Message: validationMessage(fe),
+			})
+		}
+	} else {
+		errs = append(errs, &apiError{Message: err.Error()})
+	}
+
+	return errs
+}
+
+// fieldPath returns the path of the field, without the name of the validated
+// struct, e.g. "treatments[0].factors".
+func fieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if i := strings.IndexByte(ns, '.'); i >= 0 {
+		return ns[i+1:]
+	}
+
+	return ns
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "required_if":
+		return "is required"
+	case "oneof":
+		return "must be one of: " + strings.Join(strings.Fields(fe.Param()), ", ")
+	case "min":
+		return "must be at least " + fe.Param()
+	case "gt":
+		if fe.Kind() == reflect.String {
+			return "must be longer than " + fe.Param()
+		}
+
+		return "must be greater than " + fe.Param()
+	case "gte":
+		return "must be at least " + fe.Param()
+	case "alphanumunicode":
+		return "must only contain letters and numbers"
+	default:
+		return "failed the " + fe.Tag() + " validation"
+	}
+}
+
+// readConfigFile responds with the content of the YAML configuration file, as
+// JSON, decoded into v, see loadConfigFile, with its defaults set. An invalid
+// file is still served, with its validation errors listed under "warnings":
+// it is only rejected when written.
+func readConfigFile(w http.ResponseWriter, p string, v interface{}) {
+	content, ok := loadConfigFile(w, p, v)
+	if !ok {
+		return
+	}
+
+	if d, ok := v.(defaulter); ok {
+		d.SetDefaults()
+	}
+
+	w.Header().Set("ETag", etag(content))
+
+	err := validateConfig(v)
+	if err == nil {
+		writeJSON(w, http.StatusOK, v)
+
+		return
+	}
+
+	var issues treatments.Issues
+	if errors.As(err, &issues) {
+		issues.Locate(content)
+	}
+
+	log.Warn().Err(err).Str("file", p).Msg("Invalid configuration file")
+
+	resp, err := withWarnings(v, apiErrors(err))
+	if err != nil {
+		log.Error().Err(err).Str("file", p).Msg("Failed write json")
+		writeError(w, http.StatusInternalServerError, errors.Wrap(err, "encode json"))
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// withWarnings returns the JSON object of v with the warnings added, as
+// "warnings".
+func withWarnings(v interface{}, warnings []*apiError) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	m["warnings"] = warnings
+
+	return m, nil
+}
+
+// loadConfigFile decodes the YAML configuration file into v and returns its
+// content. Otherwise, it responds with 404 Not Found if the file does not
+// exist, or 500 Internal Server Error if it cannot be read or parsed. The
+// configuration is not validated.
+func loadConfigFile(w http.ResponseWriter, p string, v interface{}) ([]byte, bool) {
+	name := filepath.Base(p)
+
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, http.StatusNotFound, errors.Errorf("%s not found", name))
+
+			return nil, false
+		}
+
+		log.Error().Err(err).Str("file", p).Msg("Failed to open yaml")
+		writeError(w, http.StatusInternalServerError, errors.Wrapf(err, "read %s", name))
+
+		return nil, false
+	}
+
+	if err := yaml.Unmarshal(content, v); err != nil {
+		log.Error().Err(err).Str("file", p).Msg("Failed read yaml")
+		writeError(w, http.StatusInternalServerError, errors.Wrapf(err, "parse %s", name))
+
+		return nil, false
+	}
+
+	return content, true
+}
+
+// writeConfigFile replaces the YAML configuration file with the JSON body of
+// the request, decoded into v. It responds with 400 Bad Request if the body is
+// not valid JSON, or 422 Unprocessable Entity if it is invalid, see also
+// saveConfigFile.
+func writeConfigFile(w http.ResponseWriter, r *http.Request, p string, v interface{}) {
+	if !decodeRequest(w, r, v) {
+		return
+	}
+
+	if err := validateConfig(v); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+
+		return
+	}
+
+	content, ok := saveConfigFile(w, r, p, v, ifMatch(r))
+	if !ok {
+		return
+	}
+
+	w.Header().Set("ETag", etag(content))
+	w.WriteHeader(http.StatusOK)
+}
+
+// decodeRequest decodes the JSON body of the request into v. Otherwise, it
+// responds with 400 Bad Request.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	b, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "read request"))
+
+		return false
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "parse json"))
+
+		return false
+	}
+
+	return true
+}
+
+// saveConfigFile writes v to the YAML configuration file, through its
+// history, and returns the content written. Otherwise, it responds with 409
+// Conflict if the file does not match the If-Match value match, see
+// matchETag, or 500 Internal Server Error if the file cannot be written.
+func saveConfigFile(w http.ResponseWriter, r *http.Request, p string, v interface{}, match string) ([]byte, bool) {
+	name := filepath.Base(p)
+
+	content, err := yaml.Marshal(v)
+	if err != nil {
+		log.Error().Err(err).Str("file", p).Msg("Failed write yaml")
+		writeError(w, http.StatusInternalServerError, errors.Wrapf(err, "encode %s", name))
+
+		return nil, false
+	}
+
+	if _, err := historyOf(p).write(content, Author(r.Context()), 0, match); err != nil {
+		if errors.Is(err, errConflict) {
+			writeError(w, http.StatusConflict, errors.Wrap(err, name))
+
+			return nil, false
+		}
+
+		log.Error().Err(err).Str("file", p).Msg("Failed to write yaml")
+		writeError(w, http.StatusInternalServerError, errors.Wrapf(err, "write %s", name))
+
+		return nil, false
+	}
+
+	return content, true
+}
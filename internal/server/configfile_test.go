@@ -0,0 +1,348 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/julienschmidt/httprouter"
+)
+
+const validTreatments = `factors:
+  - name: playerCount
+    values:
+      - value: 1
+treatments:
+  - name: solo
+    factors:
+      playerCount: 1
+`
+
+const invalidTreatments = `factors:
+  - name: playerCount
+    values:
+      - value: 1
+treatments:
+  - name: pair
+    factors:
+      playerCount: 2
+`
+
+const validLobbies = `lobbies:
+  - name: shared
+    kind: shared
+    duration: 5m
+    strategy: fail
+`
+
+// serveConfigFile calls the handler of the configuration file, created with
+// content if not empty, and returns the response.
+func serveConfigFile(t *testing.T, handler func(string) httprouter.Handle, name, content, method, body string, header http.Header) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), name)
+
+	if content != "" {
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := httptest.NewRequest(method, "/"+strings.TrimSuffix(name, ".yaml"), strings.NewReader(body))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+
+	w := httptest.NewRecorder()
+	handler(file)(w, r, nil)
+
+	return w, file
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(string) httprouter.Handle
+		file    string
+		content string
+		method  string
+		body    string
+		ifMatch string
+		code    int
+		errors  []apiError
+	}{
+		{
+			name:    "missing treatments",
+			handler: ReadTreatments,
+			file:    "treatments.yaml",
+			method:  http.MethodGet,
+			code:    http.StatusNotFound,
+			errors:  []apiError{{Message: "treatments.yaml not found"}},
+		},
+		{
+			name:    "missing lobbies",
+			handler: ReadLobbies,
+			file:    "lobbies.yaml",
+			method:  http.MethodGet,
+			code:    http.StatusNotFound,
+			errors:  []apiError{{Message: "lobbies.yaml not found"}},
+		},
+		{
+			name:    "invalid treatments yaml",
+			handler: ReadTreatments,
+			file:    "treatments.yaml",
+			content: "factors: [",
+			method:  http.MethodGet,
+			code:    http.StatusInternalServerError,
+			errors:  []apiError{{Message: "parse treatments.yaml"}},
+		},
+		{
+			name:    "generate from invalid treatments",
+			handler: GenerateTreatments,
+			file:    "treatments.yaml",
+			content: invalidTreatments,
+			method:  http.MethodPost,
+			body:    `{"design":{"factors":["playerCount"]}}`,
+			code:    http.StatusUnprocessableEntity,
+			errors:  []apiError{{Field: "treatments[0].factors.playerCount", Message: "undeclared value of factor playerCount: 2"}},
+		},
+		{
+			name:    "treatments body not json",
+			handler: WriteTreatments,
+			file:    "treatments.yaml",
+			content: validTreatments,
+			method:  http.MethodPut,
+			body:    "factors: []",
+			code:    http.StatusBadRequest,
+			errors:  []apiError{{Message: "parse json"}},
+		},
+		{
+			name:    "lobbies body not json",
+			handler: WriteLobbies,
+			file:    "lobbies.yaml",
+			content: validLobbies,
+			method:  http.MethodPut,
+			body:    `{"lobbies":`,
+			code:    http.StatusBadRequest,
+			errors:  []apiError{{Message: "parse json"}},
+		},
+		{
+			name:    "treatments with invalid fields",
+			handler: WriteTreatments,
+			file:    "treatments.yaml",
+			method:  http.MethodPut,
+			body:    `{"factors":[{"values":[{"value":1}]}],"treatments":[]}`,
+			code:    http.StatusUnprocessableEntity,
+			errors:  []apiError{{Field: "factors[0].name", Tag: "required", Message: "is required"}},
+		},
+		{
+			name:    "treatments with issues",
+			handler: WriteTreatments,
+			file:    "treatments.yaml",
+			method:  http.MethodPut,
+			body:    `{"factors":[{"name":"playerCount","values":[{"value":1}]}],"treatments":[{"factors":{"playerCount":1,"color":"red"}}]}`,
+			code:    http.StatusUnprocessableEntity,
+			errors:  []apiError{{Field: "treatments[0].factors.color", Message: "undeclared factor: color"}},
+		},
+		{
+			name:    "lobbies with invalid fields",
+			handler: WriteLobbies,
+			file:    "lobbies.yaml",
+			method:  http.MethodPut,
+			body:    `{"lobbies":[{"kind":"individual","duration":1000000000}]}`,
+			code:    http.StatusUnprocessableEntity,
+			errors:  []apiError{{Field: "lobbies[0].duration", Tag: "min", Message: "must be at least 5s"}},
+		},
+		{
+			name:    "treatments changed since read",
+			handler: WriteTreatments,
+			file:    "treatments.yaml",
+			content: validTreatments,
+			method:  http.MethodPut,
+			body:    `{"factors":[],"treatments":[]}`,
+			ifMatch: etag([]byte("factors: []\n")),
+			code:    http.StatusConflict,
+			errors:  []apiError{{Message: "treatments.yaml: file changed since it was read"}},
+		},
+		{
+			name:    "lobbies written",
+			handler: WriteLobbies,
+			file:    "lobbies.yaml",
+			content: validLobbies,
+			method:  http.MethodPut,
+			body:    `{"lobbies":[{"kind":"individual","duration":60000000000}]}`,
+			ifMatch: etag([]byte(validLobbies)),
+			code:    http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.ifMatch != "" {
+				header.Set("If-Match", tt.ifMatch)
+			}
+
+			w, file := serveConfigFile(t, tt.handler, tt.file, tt.content, tt.method, tt.body, header)
+
+			if w.Code != tt.code {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+
+			if tt.errors == nil {
+				return
+			}
+
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("got content type %q", ct)
+			}
+
+			var resp struct {
+				Errors []apiError `json:"errors"`
+			}
+
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode errors: %v: %s", err, w.Body)
+			}
+
+			if len(resp.Errors) != len(tt.errors) {
+				t.Fatalf("got errors %+v, want %+v", resp.Errors, tt.errors)
+			}
+
+			for i, want := range tt.errors {
+				got := resp.Errors[i]
+				if got.Field != want.Field || got.Tag != want.Tag || !strings.HasPrefix(got.Message, want.Message) {
+					t.Errorf("got error %+v, want %+v", got, want)
+				}
+			}
+
+			// Rejected writes leave the file unchanged.
+			content, _ := os.ReadFile(file)
+			if string(content) != tt.content {
+				t.Errorf("got file %q, want %q", content, tt.content)
+			}
+		})
+	}
+}
+
+func TestReadConfigFileWarnings(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  func(string) httprouter.Handle
+		file     string
+		content  string
+		field    string
+		warnings []apiError
+	}{
+		{
+			name:    "valid treatments",
+			handler: ReadTreatments,
+			file:    "treatments.yaml",
+			content: validTreatments,
+			field:   "treatments",
+		},
+		{
+			name:     "treatments with issues",
+			handler:  ReadTreatments,
+			file:     "treatments.yaml",
+			content:  invalidTreatments,
+			field:    "treatments",
+			warnings: []apiError{{Field: "treatments[0].factors.playerCount", Message: "undeclared value of factor playerCount: 2", Line: 8}},
+		},
+		{
+			name:     "invalid lobbies",
+			handler:  ReadLobbies,
+			file:     "lobbies.yaml",
+			content:  "lobbies:\n  - kind: both\n    duration: 5m\n",
+			field:    "lobbies",
+			warnings: []apiError{{Field: "lobbies[0].kind", Tag: "oneof", Message: "must be one of: shared, individual"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := serveConfigFile(t, tt.handler, tt.file, tt.content, http.MethodGet, "", nil)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+
+			if w.Header().Get("ETag") != etag([]byte(tt.content)) {
+				t.Errorf("got ETag %q", w.Header().Get("ETag"))
+			}
+
+			var resp map[string]jsoniter.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v: %s", err, w.Body)
+			}
+
+			if _, ok := resp[tt.field]; !ok {
+				t.Errorf("got response without %s: %s", tt.field, w.Body)
+			}
+
+			var warnings []apiError
+			if raw, ok := resp["warnings"]; ok {
+				if err := json.Unmarshal(raw, &warnings); err != nil {
+					t.Fatalf("decode warnings: %v", err)
+				}
+			}
+
+			if len(warnings) != len(tt.warnings) {
+				t.Fatalf("got warnings %+v, want %+v", warnings, tt.warnings)
+			}
+
+			for i, want := range tt.warnings {
+				if warnings[i] != want {
+					t.Errorf("got warning %+v, want %+v", warnings[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigFileDefaults(t *testing.T) {
+	body := `{"factors":[{"name":"playerCount","default":2},{"name":"rounds","default":3}],` +
+		`"treatments":[{"name":"solo","factors":{"playerCount":1}}]}`
+
+	w, file := serveConfigFile(t, WriteTreatments, "treatments.yaml", "", http.MethodPut, body, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("write: got status %d: %s", w.Code, w.Body)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(content), "rounds: 3") {
+		t.Errorf("got defaults written to the file:\n%s", content)
+	}
+
+	w, _ = serveConfigFile(t, ReadTreatments, "treatments.yaml", string(content), http.MethodGet, "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("read: got status %d: %s", w.Code, w.Body)
+	}
+
+	var resp struct {
+		Treatments []struct {
+			Factors map[string]int `json:"factors"`
+		} `json:"treatments"`
+		Warnings []apiError `json:"warnings"`
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v: %s", err, w.Body)
+	}
+
+	if len(resp.Treatments) != 1 || resp.Treatments[0].Factors["playerCount"] != 1 || resp.Treatments[0].Factors["rounds"] != 3 {
+		t.Errorf("got treatments %+v, want rounds defaulted to 3", resp.Treatments)
+	}
+
+	if len(resp.Warnings) != 0 {
+		t.Errorf("got warnings %+v", resp.Warnings)
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// errConflict is returned when a configuration file was changed since the
+// version the client read, see matchETag.
+var errConflict = errors.New("file changed since it was read")
+
+// etag returns the entity tag of the content of a configuration file.
+func etag(content []byte) string {
+	sum := sha256.Sum256(content)
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ifMatch returns the If-Match header of the request.
+func ifMatch(r *http.Request) string {
+	return r.Header.Get("If-Match")
+}
+
+// matchETag returns true if the If-Match header value matches the content.
+// An empty header always matches, so clients without ETag support can still
+// write.
+func matchETag(header string, content []byte) bool {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return true
+	}
+
+	tag := etag(content)
+
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/empiricaly/empirica/internal/treatments"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+// GenerateRequest is the body of a request to GenerateTreatments.
+type GenerateRequest struct {
+	Design *treatments.Design `json:"design"`
+
+	// Replace replaces the existing treatments with the generated treatments.
+	// Otherwise, generated treatments are added, except those with the same
+	// factors as an existing treatment.
+	Replace bool `json:"replace"`
+
+	// DryRun returns the generated treatments without writing them.
+	DryRun bool `json:"dryRun"`
+}
+
+// GenerateResponse is the response of GenerateTreatments.
+type GenerateResponse struct {
+	Generated []*treatments.Treatment `json:"generated"`
+	Added     int                     `json:"added"`
+	DryRun    bool                    `json:"dryRun"`
+}
+
+// GenerateTreatments generates treatments from the factors of the
+// treatments.yaml file, with the design of the request, and writes them to
+// the file, see GenerateRequest. It responds with 422 Unprocessable Entity if
+// the design is invalid, or if the treatments with the generated treatments
+// are invalid.
+func GenerateTreatments(p string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		req := &GenerateRequest{}
+		if !decodeRequest(w, r, req) {
+			return
+		}
+
+		t := &treatments.Treatments{}
+
+		content, ok := loadConfigFile(w, p, t)
+		if !ok {
+			return
+		}
+
+		if !matchETag(ifMatch(r), content) {
+			writeError(w, http.StatusConflict, errConflict)
+
+			return
+		}
+
+		generated, err := t.Generate(req.Design)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, errors.Wrap(err, "generate treatments"))
+
+			return
+		}
+
+		if generated == nil {
+			generated = []*treatments.Treatment{}
+		}
+
+		added := t.Add(generated, req.Replace)
+
+		if err := validateConfig(t); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+
+			return
+		}
+
+		resp := &GenerateResponse{
+			Generated: generated,
+			Added:     added,
+			DryRun:    req.DryRun,
+		}
+
+		if req.DryRun {
+			w.Header().Set("ETag", etag(content))
+			writeJSON(w, http.StatusOK, resp)
+
+			return
+		}
+
+		// The treatments are generated from the content read, the file must
+		// not have changed since.
+		content, ok = saveConfigFile(w, r, p, t, etag(content))
+		if !ok {
+			return
+		}
+
+		w.Header().Set("ETag", etag(content))
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,468 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/empiricaly/empirica/internal/settings"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/rs/zerolog/log"
+)
+
+// historyDir is the directory, next to the configuration files, where their
+// versions are kept.
+var historyDir = filepath.Join(settings.LocalDir, "history")
+
+// Version is a version of a configuration file (treatments.yaml or
+// lobbies.yaml), recorded on every write from the server.
+type Version struct {
+	Version   int    `json:"version"`
+	CreatedAt string `json:"createdAt"`
+
+	// Author identifies the credentials used to write the version, see
+	// Author. It is empty for the content of the file found before the first
+	// recorded write.
+	Author string `json:"author"`
+
+	// Diff is the unified diff from the previous version.
+	Diff string `json:"diff"`
+
+	// RollbackOf is the version restored by a rollback.
+	RollbackOf int `json:"rollbackOf,omitempty"`
+
+	Content string `json:"content,omitempty"`
+}
+
+// history is the versions of a configuration file, kept in a JSON Lines file
+// in the history directory.
+type history struct {
+	file string
+	log  string
+	mu   sync.Mutex
+}
+
+var (
+	historiesMu sync.Mutex
+	histories   = make(map[string]*history)
+)
+
+// historyOf returns the history of the configuration file. Writes to the file
+// through the history are serialized, also with other processes, see lock.
+func historyOf(file string) *history {
+	historiesMu.Lock()
+	defer historiesMu.Unlock()
+
+	key, err := filepath.Abs(file)
+	if err != nil {
+		key = file
+	}
+
+	h, ok := histories[key]
+	if !ok {
+		h = &history{
+			file: file,
+			log:  filepath.Join(filepath.Dir(file), historyDir, filepath.Base(file)+".jsonl"),
+		}
+		histories[key] = h
+	}
+
+	return h
+}
+
+// versions returns the recorded versions, oldest first.
+func (h *history) versions() ([]*Version, error) {
+	f, err := os.Open(h.log)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "open history")
+	}
+	defer f.Close()
+
+	var versions []*Version
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		v := &Version{}
+		if err := json.Unmarshal(line, v); err != nil {
+			return nil, errors.Wrap(err, "parse history")
+		}
+
+		versions = append(versions, v)
+	}
+
+	return versions, errors.Wrap(scanner.Err(), "read history")
+}
+
+// write replaces the content of the file and records the new version. It
+// returns errConflict if the file does not match the If-Match header value.
+func (h *history) write(content []byte, author string, rollbackOf int, ifMatch string) (*Version, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	unlock, err := h.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	versions, err := h.versions()
+	if err != nil {
+		return nil, err
+	}
+
+	prev, err := os.ReadFile(h.file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "read file")
+	}
+
+	if !matchETag(ifMatch, prev) {
+		return nil, errConflict
+	}
+
+	var records []*Version
+
+	// Keep the content of the file before the first write, so it can be
+	// restored.
+	if len(versions) == 0 && len(prev) > 0 {
+		records = append(records, &Version{
+			Version:   1,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+			Content:   string(prev),
+		})
+	}
+
+	number := len(versions) + len(records) + 1
+
+	v := &Version{
+		Version:    number,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339Nano),
+		Author:     author,
+		Diff:       unifiedDiff(h.file, number, string(prev), string(content)),
+		RollbackOf: rollbackOf,
+		Content:    string(content),
+	}
+
+	records = append(records, v)
+
+	if err := WriteFileAtomic(h.file, content); err != nil {
+		return nil, err
+	}
+
+	// The file must not change without a recorded version.
+	if err := h.append(records); err != nil {
+		if rerr := h.restore(prev); rerr != nil {
+			log.Error().Err(rerr).Str("file", h.file).Msg("server: restore file")
+		}
+
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// restore restores the content of the file, removed if empty.
+func (h *history) restore(prev []byte) error {
+	if len(prev) == 0 {
+		err := os.Remove(h.file)
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return errors.Wrap(err, "remove file")
+	}
+
+	return WriteFileAtomic(h.file, prev)
+}
+
+const (
+	// lockRetry is the interval between attempts to lock the history.
+	lockRetry = 10 * time.Millisecond
+
+	// lockTimeout is how long to wait for the history lock.
+	lockTimeout = 10 * time.Second
+
+	// staleLock is the age of lock files considered left by a process that
+	// crashed while holding the lock.
+	staleLock = time.Minute
+)
+
+// lock locks the history across processes, as the server and commands (see
+// WriteVersion) write the same files, with a lock file next to the history.
+// It returns the function releasing the lock.
+func (h *history) lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(h.log), 0o755); err != nil {
+		return nil, errors.Wrap(err, "create history directory")
+	}
+
+	file := h.log + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+
+			return func() {
+				if err := os.Remove(file); err != nil {
+					log.Error().Err(err).Str("file", file).Msg("server: unlock history")
+				}
+			}, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, errors.Wrap(err, "lock history")
+		}
+
+		if info, err := os.Stat(file); err == nil && time.Since(info.ModTime()) > staleLock {
+			log.Warn().Str("file", file).Msg("server: removing stale history lock")
+
+			_ = os.Remove(file)
+
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.Errorf("history locked by another process, remove %s if no other process is running", file)
+		}
+
+		time.Sleep(lockRetry)
+	}
+}
+
+// WriteVersion replaces the content of the configuration file and records it
+// as a new version, as writes from the server do. author identifies the writer
+// outside of the server, e.g. a command.
+func WriteVersion(file string, content []byte, author string) (*Version, error) {
+	return historyOf(file).write(content, author, 0, "")
+}
+
+// rollback restores the content of a version. check validates the content
+// before it is written.
+func (h *history) rollback(version int, author, ifMatch string, check func([]byte) error) (*Version, error) {
+	versions, err := h.versions()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range versions {
+		if v.Version != version {
+			continue
+		}
+
+		if err := check([]byte(v.Content)); err != nil {
+			return nil, err
+		}
+
+		return h.write([]byte(v.Content), author, version, ifMatch)
+	}
+
+	return nil, errVersionNotFound
+}
+
+var errVersionNotFound = errors.New("version not found")
+
+func (h *history) append(records []*Version) error {
+	if err := os.MkdirAll(filepath.Dir(h.log), 0o755); err != nil {
+		return errors.Wrap(err, "create history directory")
+	}
+
+	f, err := os.OpenFile(h.log, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "open history")
+	}
+
+	var buf bytes.Buffer
+
+	for _, v := range records {
+		b, err := json.Marshal(v)
+		if err != nil {
+			f.Close()
+
+			return errors.Wrap(err, "encode version")
+		}
+
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+
+		return errors.Wrap(err, "stat history")
+	}
+
+	if _, err := buf.WriteTo(f); err != nil {
+		// Remove the partially written records.
+		_ = f.Truncate(info.Size())
+		f.Close()
+
+		return errors.Wrap(err, "write history")
+	}
+
+	return errors.Wrap(f.Close(), "close history")
+}
+
+func unifiedDiff(file string, version int, a, b string) string {
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: filepath.Base(file),
+		ToFile:   filepath.Base(file) + "@" + strconv.Itoa(version),
+		Context:  3,
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("server: diff versions")
+	}
+
+	return diff
+}
+
+// WriteFileAtomic writes the file to a temporary file in the same directory,
+// then renames it, so readers never see a partially written file. Files that
+// are not versioned, such as exports, use it directly.
+func WriteFileAtomic(file string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return errors.Wrap(err, "create temporary file")
+	}
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return errors.Wrap(err, "write temporary file")
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return errors.Wrap(err, "sync temporary file")
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+
+		return errors.Wrap(err, "close temporary file")
+	}
+
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+
+		return errors.Wrap(err, "set file permissions")
+	}
+
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+
+		return errors.Wrap(err, "replace file")
+	}
+
+	return nil
+}
+
+// ReadHistory returns the versions of the configuration file, without their
+// content.
+func ReadHistory(p string) httprouter.Handle {
+	return func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+		versions, err := historyOf(p).versions()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read history")
+			writeError(w, http.StatusInternalServerError, err)
+
+			return
+		}
+
+		for _, v := range versions {
+			v.Content = ""
+		}
+
+		if versions == nil {
+			versions = []*Version{}
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Versions []*Version `json:"versions"`
+		}{
+			Versions: versions,
+		})
+	}
+}
+
+// Rollback restores a version of the configuration file, given as the
+// version parameter. check validates the content of the version. Like writes,
+// rollbacks are rejected with 409 Conflict if the If-Match header does not
+// match the current file.
+func Rollback(p string, check func([]byte) error) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		version, err := strconv.Atoi(ps.ByName("version"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.Errorf("invalid version: %s", ps.ByName("version")))
+
+			return
+		}
+
+		v, err := historyOf(p).rollback(version, Author(r.Context()), ifMatch(r), check)
+		if err != nil {
+			if errors.Is(err, errVersionNotFound) {
+				writeError(w, http.StatusNotFound, err)
+
+				return
+			}
+
+			if errors.Is(err, errConflict) {
+				writeError(w, http.StatusConflict, err)
+
+				return
+			}
+
+			log.Error().Err(err).Int("version", version).Msg("Failed to rollback")
+			writeError(w, http.StatusUnprocessableEntity, err)
+
+			return
+		}
+
+		w.Header().Set("ETag", etag([]byte(v.Content)))
+
+		v.Content = ""
+
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, val interface{}) {
+	b, err := json.Marshal(val)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed write json")
+
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if _, err := w.Write(b); err != nil {
+		log.Error().Err(err).Msg("Failed to send response")
+	}
+}
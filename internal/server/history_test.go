@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistoryConcurrentProcesses(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "treatments.yaml")
+
+	const writers, writes = 4, 10
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < writers; i++ {
+		// Histories of other processes do not share the mutex of historyOf.
+		h := &history{file: file, log: historyOf(file).log}
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < writes; j++ {
+				if _, err := h.write([]byte(fmt.Sprintf("writer %d write %d\n", i, j)), "cli", 0, ""); err != nil {
+					t.Errorf("write: %v", err)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	versions, err := historyOf(file).versions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != writers*writes {
+		t.Fatalf("got %d versions, want %d", len(versions), writers*writes)
+	}
+
+	for i, v := range versions {
+		if v.Version != i+1 {
+			t.Errorf("got version %d at %d", v.Version, i)
+		}
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if last := versions[len(versions)-1].Content; string(content) != last {
+		t.Errorf("got content %q, want last version %q", content, last)
+	}
+
+	if _, err := os.Stat(historyOf(file).log + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file not removed: %v", err)
+	}
+}
+
+func TestHistoryAppendFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		prev string
+	}{
+		{name: "existing file", prev: "treatments: []\n"},
+		{name: "new file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "treatments.yaml")
+
+			if tt.prev != "" {
+				if err := os.WriteFile(file, []byte(tt.prev), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			h := historyOf(file)
+
+			// The history cannot be opened for appending.
+			if err := os.MkdirAll(h.log, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := h.write([]byte("factors: []\n"), "cli", 0, ""); err == nil {
+				t.Fatal("got no error")
+			}
+
+			content, err := os.ReadFile(file)
+
+			switch {
+			case tt.prev == "" && !os.IsNotExist(err):
+				t.Errorf("got file %q, %v, want no file", content, err)
+			case tt.prev != "" && string(content) != tt.prev:
+				t.Errorf("got content %q, %v, want %q", content, err, tt.prev)
+			}
+		})
+	}
+}
+
+func TestHistoryStaleLock(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "treatments.yaml")
+	h := historyOf(file)
+
+	lock := h.log + ".lock"
+	if err := os.MkdirAll(filepath.Dir(lock), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(lock, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-2 * staleLock)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := WriteVersion(file, []byte("factors: []\n"), "cli"); err != nil {
+		t.Fatalf("write with stale lock: %v", err)
+	}
+}
@@ -4,13 +4,11 @@ package server
 import (
 	"context"
 	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -18,7 +16,6 @@ import (
 	"github.com/empiricaly/empirica/internal/lobbies"
 	"github.com/empiricaly/empirica/internal/templates"
 	"github.com/empiricaly/empirica/internal/treatments"
-	"github.com/go-playground/validator/v10"
 	"github.com/jpillora/backoff"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/julienschmidt/httprouter"
@@ -61,11 +58,30 @@ func Start(
 	return s, nil
 }
 
+// corsHandler allows cross-origin requests, like cors.AllowAll, and exposes
+// the ETag header, used by the admin UI to avoid overwriting concurrent
+// changes to treatments and lobbies.
+func corsHandler(h http.Handler) http.Handler {
+	return cors.New(cors.Options{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{
+			http.MethodHead,
+			http.MethodGet,
+			http.MethodPost,
+			http.MethodPut,
+			http.MethodPatch,
+			http.MethodDelete,
+		},
+		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{"ETag"},
+	}).Handler(h)
+}
+
 // Start creates and starts the GraphQL HTTP server.
 func (s *Server) Start(ctx context.Context) (err error) {
 	srv := &http.Server{
 		Addr:        s.config.Addr,
-		Handler:     cors.AllowAll().Handler(s.Router),
+		Handler:     corsHandler(RecordLogins(s.Router)),
 		BaseContext: func(_ net.Listener) context.Context { return ctx },
 	}
 
@@ -162,15 +178,30 @@ func Enable(
 	router.NotFound = prox
 
 	router.GET("/dev", DevCheck(config.Production))
-	router.GET("/treatments", ReadTreatments(config.Treatments))
-	router.PUT("/treatments", WriteTreatments(config.Treatments))
-	router.GET("/lobbies", ReadLobbies(config.Lobbies))
-	router.PUT("/lobbies", WriteLobbies(config.Lobbies))
+	EnableConfigFiles(config, router)
 	router.ServeFiles("/admin/*filepath", templates.HTTPFS("admin-ui"))
 
 	return nil
 }
 
+// EnableConfigFiles adds the endpoints reading and writing the treatments and
+// lobbies configuration files, with their history, protected by Auth. Reads
+// return an ETag of the file, writes with an If-Match header not matching the
+// current file are rejected with 409 Conflict.
+func EnableConfigFiles(config *Config, router *httprouter.Router) {
+	auth := NewAuth(config, router)
+
+	router.GET("/treatments", auth.Protect(ReadTreatments(config.Treatments)))
+	router.PUT("/treatments", auth.Protect(WriteTreatments(config.Treatments)))
+	router.POST("/treatments/generate", auth.Protect(GenerateTreatments(config.Treatments)))
+	router.GET("/treatments/history", auth.Protect(ReadHistory(config.Treatments)))
+	router.POST("/treatments/rollback/:version", auth.Protect(Rollback(config.Treatments, checkTreatments)))
+	router.GET("/lobbies", auth.Protect(ReadLobbies(config.Lobbies)))
+	router.PUT("/lobbies", auth.Protect(WriteLobbies(config.Lobbies)))
+	router.GET("/lobbies/history", auth.Protect(ReadHistory(config.Lobbies)))
+	router.POST("/lobbies/rollback/:version", auth.Protect(Rollback(config.Lobbies, checkLobbies)))
+}
+
 func index(scheme, host string) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		u, err := url.Parse(r.URL.String())
@@ -277,153 +308,63 @@ func DevCheck(isProd bool) httprouter.Handle {
 	}
 }
 
-// TODO sercure these endpoints
+// ReadTreatments responds with the treatments of the treatments.yaml file, as
+// JSON, see readConfigFile.
 func ReadTreatments(p string) httprouter.Handle {
 	return func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
-		content, err := ioutil.ReadFile(p)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to open yaml")
-		}
-
-		t := &treatments.Treatments{}
-
-		err = yaml.Unmarshal(content, &t)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed read yaml")
-		}
-
-		if err := validator.New().Struct(t); err != nil {
-			log.Error().Err(err).Msg("Failed to parse treatments.yaml")
-
-			w.WriteHeader(http.StatusUnprocessableEntity)
-			_, _ = w.Write([]byte(err.Error()))
-
-			return
-		}
-
-		contentJSON, err := json.Marshal(t)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed write json")
-		}
-
-		_, err = w.Write(contentJSON)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to send response for index")
-		}
+		readConfigFile(w, p, &treatments.Treatments{})
 	}
 }
 
+// WriteTreatments replaces the treatments.yaml file with the treatments of the
+// request, as JSON, see writeConfigFile.
 func WriteTreatments(p string) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		b, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed read json")
-		}
-
-		r.Body.Close()
-
-		t := &treatments.Treatments{}
-		if err := json.Unmarshal(b, &t); err != nil {
-			log.Error().Err(err).Msg("Failed write json")
-		}
-
-		if err := validator.New().Struct(t); err != nil {
-			log.Error().Err(err).Msg("Failed to parse treatments.yaml")
-
-			w.WriteHeader(http.StatusUnprocessableEntity)
-			_, _ = w.Write([]byte(err.Error()))
-
-			return
-		}
-
-		content, err := yaml.Marshal(t)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed write yaml")
-		}
-
-		err = ioutil.WriteFile(p, content, 0o644)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to open yaml")
-		}
+		writeConfigFile(w, r, p, &treatments.Treatments{})
 	}
 }
 
-// TODO sercure these endpoints
+// ReadLobbies responds with the lobby configurations of the lobbies.yaml
+// file, as JSON, see readConfigFile.
 func ReadLobbies(p string) httprouter.Handle {
 	return func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
-		content, err := ioutil.ReadFile(p)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to open yaml")
-		}
-
-		t := &lobbies.Lobbies{}
-
-		err = yaml.Unmarshal(content, &t)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed read yaml")
-		}
-
-		if err := validator.New().Struct(t); err != nil {
-			log.Error().Err(err).Msg("Failed to parse lobbies.yaml")
-
-			w.WriteHeader(http.StatusUnprocessableEntity)
-
-			val := struct {
-				Errors []string `json:"errors"`
-			}{
-				Errors: strings.Split(err.Error(), "\n"),
-			}
-
-			if b, err := json.Marshal(val); err == nil {
-				_, _ = w.Write(b)
-			}
-
-			return
-		}
-
-		contentJSON, err := json.Marshal(t)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed write json")
-		}
-
-		_, err = w.Write(contentJSON)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to send response for index")
-		}
+		readConfigFile(w, p, &lobbies.Lobbies{})
 	}
 }
 
+// WriteLobbies replaces the lobbies.yaml file with the lobby configurations
+// of the request, as JSON, see writeConfigFile.
 func WriteLobbies(p string) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		b, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed read json")
-		}
+		writeConfigFile(w, r, p, &lobbies.Lobbies{})
+	}
+}
 
-		r.Body.Close()
+// checkTreatments validates the content of a treatments.yaml file.
+func checkTreatments(content []byte) error {
+	t := &treatments.Treatments{}
 
-		t := &lobbies.Lobbies{}
-		if err := json.Unmarshal(b, &t); err != nil {
-			log.Error().Err(err).Msg("Failed write json")
-		}
+	if err := yaml.Unmarshal(content, t); err != nil {
+		return errors.Wrap(err, "parse treatments")
+	}
 
-		if err := validator.New().Struct(t); err != nil {
-			log.Error().Err(err).Msg("Failed to parse lobbies.yaml")
+	err := validateConfig(t)
 
-			w.WriteHeader(http.StatusUnprocessableEntity)
-			_, _ = w.Write([]byte(err.Error()))
+	var issues treatments.Issues
+	if errors.As(err, &issues) {
+		issues.Locate(content)
+	}
 
-			return
-		}
+	return errors.Wrap(err, "validate treatments")
+}
 
-		content, err := yaml.Marshal(t)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed write yaml")
-		}
+// checkLobbies validates the content of a lobbies.yaml file.
+func checkLobbies(content []byte) error {
+	l := &lobbies.Lobbies{}
 
-		err = ioutil.WriteFile(p, content, 0o644)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to open yaml")
-		}
+	if err := yaml.Unmarshal(content, l); err != nil {
+		return errors.Wrap(err, "parse lobbies")
 	}
+
+	return errors.Wrap(validateConfig(l), "validate lobbies")
 }
@@ -0,0 +1,371 @@
+package treatments
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// PlayerCount is the factor required on every treatment: the number of
+// players in a game.
+const PlayerCount = "playerCount"
+
+// Issue is a problem of the treatments. Field is the path of the field, e.g.
+// "treatments[2].factors.color". Line and Column locate the field in the YAML
+// file, see Issues.Locate.
+type Issue struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+}
+
+func (i *Issue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("%d:%d: %s: %s", i.Line, i.Column, i.Field, i.Message)
+	}
+
+	return i.Field + ": " + i.Message
+}
+
+// Issues are the problems of the treatments, as an error.
+type Issues []*Issue
+
+func (is Issues) Error() string {
+	s := make([]string, len(is))
+	for i, issue := range is {
+		s[i] = issue.String()
+	}
+
+	return strings.Join(s, "\n")
+}
+
+// Check returns the Issues of the treatments not covered by the struct tags:
+// duplicate factors, factor values and treatment names, factors of treatments
+// that are not declared, or with values not declared, defaults and values not
+// matching the type, bounds or allowed values of their factor, treatments
+// without a valid playerCount or other required factors, unless their factor
+// has a default, and treatments with a targetCount above their maxGames. It
+// returns nil if there are no issues. Defaults are not set, see SetDefaults.
+func (t *Treatments) Check() error {
+	var issues Issues
+
+	add := func(field, format string, args ...interface{}) {
+		issues = append(issues, &Issue{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	factors := make(map[string]*Factor, len(t.Factors))
+
+	for i, f := range t.Factors {
+		if f == nil {
+			continue
+		}
+
+		if _, ok := factors[f.Name]; ok {
+			add(fmt.Sprintf("factors[%d].name", i), "duplicate factor: %s", f.Name)
+
+			continue
+		}
+
+		factors[f.Name] = f
+		field := fmt.Sprintf("factors[%d]", i)
+
+		if (f.Min != nil || f.Max != nil) && !f.numeric() {
+			add(field+".type", "min and max require an integer or number type")
+		}
+
+		if f.Min != nil && f.Max != nil && *f.Min > *f.Max {
+			add(field+".min", "min is greater than max")
+		}
+
+		for j, v := range f.Enum {
+			if msg := f.checkType(v); msg != "" {
+				add(fmt.Sprintf("%s.enum[%d]", field, j), "value of factor %s %s: %v", f.Name, msg, v)
+			}
+		}
+
+		if f.Default != nil {
+			if msg := f.checkValue(f.Default); msg != "" {
+				add(field+".default", "default of factor %s %s: %v", f.Name, msg, f.Default)
+			} else if f.Name == PlayerCount && !positiveInteger(f.Default) {
+				add(field+".default", "default %s must be a positive integer: %v", PlayerCount, f.Default)
+			} else if len(f.Values) > 0 && !hasValue(f, f.Default) {
+				add(field+".default", "undeclared default of factor %s: %v", f.Name, f.Default)
+			}
+		}
+
+		values := make(map[string]bool, len(f.Values))
+
+		for j, v := range f.Values {
+			if v == nil {
+				continue
+			}
+
+			vfield := fmt.Sprintf("%s.values[%d].value", field, j)
+
+			if msg := f.checkValue(v.Value); msg != "" {
+				add(vfield, "value of factor %s %s: %v", f.Name, msg, v.Value)
+			}
+
+			s := fmt.Sprint(v.Value)
+			if values[s] {
+				add(vfield, "duplicate value of factor %s: %s", f.Name, s)
+			}
+
+			values[s] = true
+		}
+	}
+
+	names := make(map[string]int, len(t.Treatments))
+
+	for i, treatment := range t.Treatments {
+		if treatment == nil {
+			continue
+		}
+
+		field := fmt.Sprintf("treatments[%d]", i)
+
+		if treatment.Name != "" {
+			if j, ok := names[treatment.Name]; ok {
+				add(field+".name", "duplicate treatment name: %s, also treatments[%d]", treatment.Name, j)
+			} else {
+				names[treatment.Name] = i
+			}
+		}
+
+		if treatment.MaxGames > 0 && treatment.TargetCount > treatment.MaxGames {
+			add(field+".targetCount", "targetCount is greater than maxGames: %d > %d", treatment.TargetCount, treatment.MaxGames)
+		}
+
+		if val, ok := treatment.Factors[PlayerCount]; !ok {
+			// An invalid default is reported on the factor.
+			if f := factors[PlayerCount]; f == nil || f.Default == nil {
+				add(field+".factors", "%s is required", PlayerCount)
+			}
+		} else if !positiveInteger(val) {
+			add(field+".factors."+PlayerCount, "%s must be a positive integer: %v", PlayerCount, val)
+		}
+
+		keys := make([]string, 0, len(treatment.Factors))
+		for k := range treatment.Factors {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			f, ok := factors[k]
+			if !ok {
+				add(field+".factors."+k, "undeclared factor: %s", k)
+
+				continue
+			}
+
+			// An invalid playerCount is already reported.
+			if k == PlayerCount && !positiveInteger(treatment.Factors[k]) {
+				continue
+			}
+
+			if msg := f.checkValue(treatment.Factors[k]); msg != "" {
+				add(field+".factors."+k, "value of factor %s %s: %v", k, msg, treatment.Factors[k])
+			} else if len(f.Values) > 0 && !hasValue(f, treatment.Factors[k]) {
+				add(field+".factors."+k, "undeclared value of factor %s: %v", k, treatment.Factors[k])
+			}
+		}
+
+		for _, f := range t.Factors {
+			if f == nil || !f.Required || f.Default != nil || f.Name == PlayerCount {
+				continue
+			}
+
+			if _, ok := treatment.Factors[f.Name]; !ok {
+				add(field+".factors", "%s is required", f.Name)
+			}
+		}
+	}
+
+	if len(issues) == 0 {
+		return nil
+	}
+
+	return issues
+}
+
+// Lint returns the Issues of the content of a treatments.yaml file: the
+// struct tag validation errors and the Issues of Check, located in the file
+// and sorted by line. It returns an error if the content is not valid YAML.
+func Lint(content []byte) (Issues, error) {
+	t := &Treatments{}
+	if err := yaml.Unmarshal(content, t); err != nil {
+		return nil, errors.Wrap(err, "parse treatments")
+	}
+
+	var issues Issues
+
+	var verrs validator.ValidationErrors
+
+	if err := lintValidator.Struct(t); err != nil {
+		if !errors.As(err, &verrs) {
+			return nil, errors.Wrap(err, "validate treatments")
+		}
+
+		for _, fe := range verrs {
+			field := fe.Namespace()
+			if i := strings.IndexByte(field, '.'); i >= 0 {
+				field = field[i+1:]
+			}
+
+			msg := "failed the " + fe.Tag() + " validation"
+			if fe.Param() != "" {
+				msg += " (" + fe.Param() + ")"
+			}
+
+			issues = append(issues, &Issue{Field: field, Message: msg})
+		}
+	}
+
+	var cissues Issues
+	if err := t.Check(); errors.As(err, &cissues) {
+		issues = append(issues, cissues...)
+	}
+
+	issues.Locate(content)
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Line < issues[j].Line
+	})
+
+	return issues, nil
+}
+
+// lintValidator names fields by their YAML name.
+var lintValidator = func() *validator.Validate {
+	v := validator.New()
+
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("yaml"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+
+		return name
+	})
+
+	return v
+}()
+
+// Locate sets the line and column of the issues in the YAML content. Issues
+// of fields missing from the content are located at their closest parent.
+func (is Issues) Locate(content []byte) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(content, doc); err != nil || len(doc.Content) == 0 {
+		return
+	}
+
+	for _, issue := range is {
+		if n := locate(doc.Content[0], splitField(issue.Field)); n != nil {
+			issue.Line = n.Line
+			issue.Column = n.Column
+		}
+	}
+}
+
+// locate returns the node of the path, the key node for mapping values, or
+// the closest parent found.
+func locate(node *yaml.Node, path []string) *yaml.Node {
+	for len(path) > 0 {
+		switch node.Kind {
+		case yaml.MappingNode:
+			var next *yaml.Node
+
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == path[0] {
+					if len(path) == 1 {
+						return node.Content[i]
+					}
+
+					next = node.Content[i+1]
+
+					break
+				}
+			}
+
+			if next == nil {
+				return node
+			}
+
+			node = next
+		case yaml.SequenceNode:
+			i, err := strconv.Atoi(path[0])
+			if err != nil || i < 0 || i >= len(node.Content) {
+				return node
+			}
+
+			node = node.Content[i]
+		case yaml.AliasNode:
+			node = node.Alias
+
+			continue
+		default:
+			return node
+		}
+
+		path = path[1:]
+	}
+
+	return node
+}
+
+// splitField splits a field path, e.g. "treatments[2].factors[key]" into
+// "treatments", "2", "factors", "key".
+func splitField(field string) []string {
+	var path []string
+
+	for _, part := range strings.Split(field, ".") {
+		for {
+			i := strings.IndexByte(part, '[')
+			if i < 0 || !strings.HasSuffix(part, "]") {
+				break
+			}
+
+			if i > 0 {
+				path = append(path, part[:i])
+			}
+
+			j := strings.IndexByte(part[i:], ']')
+			path = append(path, part[i+1:i+j])
+			part = part[i+j+1:]
+		}
+
+		if part != "" {
+			path = append(path, part)
+		}
+	}
+
+	return path
+}
+
+func hasValue(f *Factor, val interface{}) bool {
+	s := fmt.Sprint(val)
+
+	for _, v := range f.Values {
+		if v != nil && fmt.Sprint(v.Value) == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func positiveInteger(val interface{}) bool {
+	n, ok := number(val)
+
+	return ok && n > 0 && n == math.Trunc(n)
+}
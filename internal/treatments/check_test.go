@@ -0,0 +1,330 @@
+package treatments
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const lintFactors = `factors:
+  - name: playerCount
+    values:
+      - value: 1
+      - value: 2
+  - name: rounds
+    type: integer
+    min: 1
+    max: 10
+treatments:
+`
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name       string
+		treatments string
+		field      string
+		message    string
+		line       int
+		column     int
+	}{
+		{
+			name: "duplicate treatment name",
+			treatments: `  - name: solo
+    factors:
+      playerCount: 1
+  - name: solo
+    factors:
+      playerCount: 2
+`,
+			field:   "treatments[1].name",
+			message: "duplicate treatment name: solo",
+			line:    14,
+			column:  5,
+		},
+		{
+			name: "unknown factor",
+			treatments: `  - name: solo
+    factors:
+      playerCount: 1
+      color: red
+`,
+			field:   "treatments[0].factors.color",
+			message: "undeclared factor: color",
+			line:    14,
+			column:  7,
+		},
+		{
+			name: "out of range value",
+			treatments: `  - name: solo
+    factors:
+      playerCount: 1
+  - name: long
+    factors:
+      playerCount: 2
+      rounds: 12
+`,
+			field:   "treatments[1].factors.rounds",
+			message: "must be at most 10",
+			line:    17,
+			column:  7,
+		},
+		{
+			name: "missing playerCount",
+			treatments: `  - name: solo
+    factors:
+      rounds: 2
+`,
+			field:   "treatments[0].factors",
+			message: "playerCount is required",
+			line:    12,
+			column:  5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues, err := Lint([]byte(lintFactors + tt.treatments))
+			if err != nil {
+				t.Fatalf("lint: %v", err)
+			}
+
+			if len(issues) != 1 {
+				t.Fatalf("got %d issues, want 1: %v", len(issues), issues)
+			}
+
+			issue := issues[0]
+
+			if issue.Field != tt.field {
+				t.Errorf("got field %s, want %s", issue.Field, tt.field)
+			}
+
+			if !strings.Contains(issue.Message, tt.message) {
+				t.Errorf("got message %q, want %q", issue.Message, tt.message)
+			}
+
+			if issue.Line != tt.line || issue.Column != tt.column {
+				t.Errorf("got %d:%d, want %d:%d", issue.Line, issue.Column, tt.line, tt.column)
+			}
+		})
+	}
+}
+
+func TestLintSorted(t *testing.T) {
+	issues, err := Lint([]byte(lintFactors + `  - name: a
+    factors:
+      playerCount: 3
+  - name: a
+    factors:
+      playerCount: 1
+      color: red
+`))
+	if err != nil {
+		t.Fatalf("lint: %v", err)
+	}
+
+	if len(issues) != 3 {
+		t.Fatalf("got %d issues, want 3: %v", len(issues), issues)
+	}
+
+	for i := 1; i < len(issues); i++ {
+		if issues[i].Line < issues[i-1].Line {
+			t.Errorf("issue %d at line %d before issue at line %d", i, issues[i].Line, issues[i-1].Line)
+		}
+	}
+}
+
+func TestCheckTypedFactors(t *testing.T) {
+	tests := []struct {
+		name    string
+		factors string
+		factor  string
+		issues  []string
+	}{
+		{
+			name:    "valid",
+			factors: "type: integer\n    min: 1\n    max: 10\n    values:\n      - value: 1\n      - value: 10",
+			factor:  "1",
+		},
+		{
+			name:    "not a string",
+			factors: "type: string",
+			factor:  "1",
+			issues:  []string{"treatments[0].factors.f: value of factor f must be a string: 1"},
+		},
+		{
+			name:    "not a boolean",
+			factors: "type: boolean",
+			factor:  "yes please",
+			issues:  []string{"treatments[0].factors.f: value of factor f must be a boolean: yes please"},
+		},
+		{
+			name:    "not a number",
+			factors: "type: number",
+			factor:  "high",
+			issues:  []string{"treatments[0].factors.f: value of factor f must be a number: high"},
+		},
+		{
+			name:    "not an integer",
+			factors: "type: integer",
+			factor:  "1.5",
+			issues:  []string{"treatments[0].factors.f: value of factor f must be an integer: 1.5"},
+		},
+		{
+			name:    "below min",
+			factors: "type: number\n    min: 0.5",
+			factor:  "0.25",
+			issues:  []string{"treatments[0].factors.f: value of factor f must be at least 0.5: 0.25"},
+		},
+		{
+			name:    "above max",
+			factors: "type: integer\n    max: 3",
+			factor:  "4",
+			issues:  []string{"treatments[0].factors.f: value of factor f must be at most 3: 4"},
+		},
+		{
+			name:    "min greater than max",
+			factors: "type: integer\n    min: 3\n    max: 1",
+			issues:  []string{"factors[1].min: min is greater than max"},
+		},
+		{
+			name:    "min of a string",
+			factors: "type: string\n    min: 1",
+			issues:  []string{"factors[1].type: min and max require an integer or number type"},
+		},
+		{
+			name:    "not in enum",
+			factors: "enum: [red, blue]",
+			factor:  "green",
+			issues:  []string{"treatments[0].factors.f: value of factor f must be one of: red, blue: green"},
+		},
+		{
+			name:    "enum of another type",
+			factors: "type: integer\n    enum: [1, two]",
+			issues:  []string{"factors[1].enum[1]: value of factor f must be a number: two"},
+		},
+		{
+			name:    "undeclared value",
+			factors: "values:\n      - value: 1",
+			factor:  "2",
+			issues:  []string{"treatments[0].factors.f: undeclared value of factor f: 2"},
+		},
+		{
+			name:    "required",
+			factors: "required: true",
+			issues:  []string{"treatments[0].factors: f is required"},
+		},
+		{
+			name:    "required with default",
+			factors: "required: true\n    default: 1",
+		},
+		{
+			name:    "invalid default",
+			factors: "type: integer\n    default: one",
+			issues:  []string{"factors[1].default: default of factor f must be a number: one"},
+		},
+		{
+			name:    "undeclared default",
+			factors: "default: 3\n    values:\n      - value: 1",
+			issues:  []string{"factors[1].default: undeclared default of factor f: 3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := "factors:\n  - name: playerCount\n  - name: f\n    " + tt.factors +
+				"\ntreatments:\n  - factors:\n      playerCount: 1\n"
+			if tt.factor != "" {
+				content += "      f: " + tt.factor + "\n"
+			}
+
+			tr := &Treatments{}
+			if err := yaml.Unmarshal([]byte(content), tr); err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			var issues Issues
+			if err := tr.Check(); err != nil && !errors.As(err, &issues) {
+				t.Fatalf("check: %v", err)
+			}
+
+			if len(issues) != len(tt.issues) {
+				t.Fatalf("got issues %v, want %q", issues, tt.issues)
+			}
+
+			for i, want := range tt.issues {
+				if got := issues[i].String(); got != want {
+					t.Errorf("got issue %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckPlayerCountDefault(t *testing.T) {
+	tests := []struct {
+		name   string
+		def    string
+		issues []string
+	}{
+		{name: "default", def: "2"},
+		{name: "no default", issues: []string{"treatments[0].factors: playerCount is required"}},
+		{name: "invalid default", def: "-1", issues: []string{"factors[0].default: default playerCount must be a positive integer: -1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := "factors:\n  - name: playerCount\n"
+			if tt.def != "" {
+				content += "    default: " + tt.def + "\n"
+			}
+
+			content += "treatments:\n  - name: solo\n"
+
+			tr := &Treatments{}
+			if err := yaml.Unmarshal([]byte(content), tr); err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			var issues Issues
+			if err := tr.Check(); err != nil && !errors.As(err, &issues) {
+				t.Fatalf("check: %v", err)
+			}
+
+			if len(issues) != len(tt.issues) {
+				t.Fatalf("got issues %v, want %q", issues, tt.issues)
+			}
+
+			for i, want := range tt.issues {
+				if got := issues[i].String(); got != want {
+					t.Errorf("got issue %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLintInvalidYAML(t *testing.T) {
+	if _, err := Lint([]byte("factors: [")); err == nil {
+		t.Error("got no error for invalid YAML")
+	}
+}
+
+func TestSplitField(t *testing.T) {
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{"treatments[2].factors.color", "treatments/2/factors/color"},
+		{"factors[0].values[1].value", "factors/0/values/1/value"},
+		{"treatments[0].factors[key]", "treatments/0/factors/key"},
+		{"name", "name"},
+	}
+
+	for _, tt := range tests {
+		if got := strings.Join(splitField(tt.field), "/"); got != tt.want {
+			t.Errorf("splitField(%s): got %s, want %s", tt.field, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package treatments
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// Columns of the treatments CSV layout, besides one column per factor.
+const (
+	columnName        = "name"
+	columnDesc        = "desc"
+	columnWeight      = "weight"
+	columnTargetCount = "targetCount"
+	columnMaxGames    = "maxGames"
+)
+
+var reservedColumns = []string{columnName, columnDesc, columnWeight, columnTargetCount, columnMaxGames}
+
+// WriteCSV writes the treatments as CSV, one row per treatment: name, desc,
+// one column per factor, then weight, targetCount and maxGames if set on any
+// treatment. Factor values that are objects or arrays are written as JSON.
+// Factors not set on a treatment are empty.
+func (t *Treatments) WriteCSV(w io.Writer, comma rune) error {
+	factors, err := t.csvFactors()
+	if err != nil {
+		return err
+	}
+
+	var quotas []string
+
+	for _, col := range reservedColumns[2:] {
+		for _, treatment := range t.Treatments {
+			if treatment != nil && treatment.quota(col) != 0 {
+				quotas = append(quotas, col)
+
+				break
+			}
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+
+	header := append(append([]string{columnName, columnDesc}, factors...), quotas...)
+	if err := cw.Write(header); err != nil {
+		return errors.Wrap(err, "write header")
+	}
+
+	for _, treatment := range t.Treatments {
+		if treatment == nil {
+			continue
+		}
+
+		row := []string{treatment.Name, treatment.Desc}
+
+		for _, name := range factors {
+			val, ok := treatment.Factors[name]
+			if !ok {
+				row = append(row, "")
+
+				continue
+			}
+
+			s, err := formatCell(val)
+			if err != nil {
+				return errors.Wrapf(err, "treatment %s: factor %s", treatment.Name, name)
+			}
+
+			row = append(row, s)
+		}
+
+		for _, col := range quotas {
+			s := ""
+			if q := treatment.quota(col); q != 0 {
+				s = strconv.FormatFloat(q, 'f', -1, 64)
+			}
+
+			row = append(row, s)
+		}
+
+		if err := cw.Write(row); err != nil {
+			return errors.Wrap(err, "write row")
+		}
+	}
+
+	cw.Flush()
+
+	return errors.Wrap(cw.Error(), "write csv")
+}
+
+// ReadCSV replaces the treatments with the rows of a CSV file, in the layout
+// of WriteCSV. The name column is required, other columns are optional.
+// Columns of factors that are not declared add a factor, and values not
+// declared are added to the Values of factors that have Values. Empty cells
+// leave the factor unset on the treatment. Cells are parsed with
+// the type of their factor, and as numbers, booleans or JSON if the factor is
+// not typed and the value is not declared.
+func (t *Treatments) ReadCSV(r io.Reader, comma rune) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "read csv")
+	}
+
+	// Spreadsheet applications may start UTF-8 files with a byte order mark.
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+
+	cr := csv.NewReader(bytes.NewReader(content))
+	cr.Comma = comma
+	// Leading spaces would include the empty cells of whitespace delimiters,
+	// such as tabs. Cells are trimmed below.
+	cr.TrimLeadingSpace = !unicode.IsSpace(comma)
+
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return errors.Wrap(err, "parse csv")
+	}
+
+	if len(rows) == 0 {
+		return errors.New("empty csv")
+	}
+
+	header := rows[0]
+	seen := make(map[string]bool, len(header))
+	hasName := false
+
+	for i, col := range header {
+		col = strings.TrimSpace(col)
+		header[i] = col
+
+		if col == "" {
+			return errors.Errorf("column %d: empty header", i+1)
+		}
+
+		if seen[col] {
+			return errors.Errorf("duplicate column: %s", col)
+		}
+
+		seen[col] = true
+		hasName = hasName || col == columnName
+	}
+
+	if !hasName {
+		return errors.Errorf("missing %s column", columnName)
+	}
+
+	treatments := make([]*Treatment, 0, len(rows)-1)
+
+	for i, row := range rows[1:] {
+		line := i + 2
+
+		// Spreadsheet applications may export empty rows as separators only.
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+
+		treatment := &Treatment{Factors: make(map[string]interface{})}
+
+		for j, col := range header {
+			cell := strings.TrimSpace(row[j])
+
+			var err error
+
+			switch col {
+			case columnName:
+				treatment.Name = cell
+			case columnDesc:
+				treatment.Desc = cell
+			case columnWeight:
+				treatment.Weight, err = parseWeight(cell)
+			case columnTargetCount:
+				treatment.TargetCount, err = parseCount(cell)
+			case columnMaxGames:
+				treatment.MaxGames, err = parseCount(cell)
+			default:
+				if cell == "" {
+					continue
+				}
+
+				treatment.Factors[col], err = t.parseCell(col, cell)
+			}
+
+			if err != nil {
+				return errors.Wrapf(err, "line %d: column %s", line, col)
+			}
+		}
+
+		treatments = append(treatments, treatment)
+	}
+
+	for _, treatment := range treatments {
+		t.declare(treatment)
+	}
+
+	t.Treatments = treatments
+
+	return nil
+}
+
+// csvFactors returns the factor columns: the declared factors, then the
+// factors of the treatments that are not declared, sorted.
+func (t *Treatments) csvFactors() ([]string, error) {
+	var factors []string
+
+	seen := make(map[string]bool)
+
+	for _, f := range t.Factors {
+		if f != nil && !seen[f.Name] {
+			factors = append(factors, f.Name)
+			seen[f.Name] = true
+		}
+	}
+
+	var undeclared []string
+
+	for _, treatment := range t.Treatments {
+		if treatment == nil {
+			continue
+		}
+
+		for name := range treatment.Factors {
+			if !seen[name] {
+				undeclared = append(undeclared, name)
+				seen[name] = true
+			}
+		}
+	}
+
+	sort.Strings(undeclared)
+	factors = append(factors, undeclared...)
+
+	for _, name := range factors {
+		for _, col := range reservedColumns {
+			if name == col {
+				return nil, errors.Errorf("factor %s conflicts with the %s column", name, col)
+			}
+		}
+	}
+
+	return factors, nil
+}
+
+// declare adds the factors of the treatment that are not declared, and their
+// values that are not declared to factors with values.
+func (t *Treatments) declare(treatment *Treatment) {
+	names := make([]string, 0, len(treatment.Factors))
+	for name := range treatment.Factors {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		val := treatment.Factors[name]
+
+		f := t.factor(name)
+		if f == nil {
+			f = &Factor{Name: name}
+			t.Factors = append(t.Factors, f)
+		} else if len(f.Values) == 0 {
+			continue
+		}
+
+		if !hasValue(f, val) {
+			f.Values = append(f.Values, &FactorValue{Value: val})
+		}
+	}
+}
+
+// parseCell parses the value of a factor: with the type of the factor, as a
+// declared value of the factor, or as a number, boolean or JSON value.
+func (t *Treatments) parseCell(name, cell string) (interface{}, error) {
+	f := t.factor(name)
+
+	if f != nil {
+		switch f.Type {
+		case TypeString:
+			return cell, nil
+		case TypeInteger:
+			n, err := strconv.Atoi(cell)
+
+			return n, errors.Wrap(err, "parse integer")
+		case TypeNumber:
+			n, err := strconv.ParseFloat(cell, 64)
+
+			return n, errors.Wrap(err, "parse number")
+		case TypeBoolean:
+			b, err := strconv.ParseBool(cell)
+
+			return b, errors.Wrap(err, "parse boolean")
+		}
+
+		for _, v := range f.Values {
+			if v != nil && fmt.Sprint(v.Value) == cell {
+				return v.Value, nil
+			}
+		}
+	}
+
+	if n, err := strconv.Atoi(cell); err == nil {
+		return n, nil
+	}
+
+	if n, err := strconv.ParseFloat(cell, 64); err == nil {
+		return n, nil
+	}
+
+	if cell == "true" || cell == "false" {
+		return cell == "true", nil
+	}
+
+	if strings.HasPrefix(cell, "{") || strings.HasPrefix(cell, "[") {
+		var v interface{}
+		if err := json.Unmarshal([]byte(cell), &v); err == nil {
+			return v, nil
+		}
+	}
+
+	return cell, nil
+}
+
+func (t *Treatment) quota(col string) float64 {
+	switch col {
+	case columnWeight:
+		return t.Weight
+	case columnTargetCount:
+		return float64(t.TargetCount)
+	case columnMaxGames:
+		return float64(t.MaxGames)
+	default:
+		return 0
+	}
+}
+
+func parseWeight(cell string) (float64, error) {
+	if cell == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseFloat(cell, 64)
+
+	return n, errors.Wrap(err, "parse number")
+}
+
+func parseCount(cell string) (int, error) {
+	if cell == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(cell)
+
+	return n, errors.Wrap(err, "parse integer")
+}
+
+// formatCell formats a factor value: objects and arrays as JSON, other values
+// as text.
+func formatCell(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+		b, err := json.Marshal(jsonValue(v))
+		if err != nil {
+			return "", errors.Wrap(err, "encode value")
+		}
+
+		return string(b), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// jsonValue converts the maps decoded from YAML, with interface{} keys, to
+// maps that can be encoded to JSON.
+func jsonValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = jsonValue(e)
+		}
+
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, e := range v {
+			s[i] = jsonValue(e)
+		}
+
+		return s
+	default:
+		return v
+	}
+}
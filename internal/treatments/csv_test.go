@@ -0,0 +1,161 @@
+package treatments
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func csvTreatments() *Treatments {
+	return &Treatments{
+		Factors: []*Factor{
+			{Name: "playerCount", Type: TypeInteger},
+			{Name: "ratio", Type: TypeNumber},
+			{Name: "chat", Type: TypeBoolean},
+			{Name: "code", Type: TypeString},
+			{Name: "mode", Values: []*FactorValue{{Value: "fast"}, {Value: "slow"}}},
+			{Name: "config"},
+		},
+		Treatments: []*Treatment{
+			{
+				Name: "control",
+				Desc: "no chat, with a comma",
+				Factors: map[string]interface{}{
+					"playerCount": 1,
+					"ratio":       0.5,
+					"chat":        false,
+					"code":        "007",
+					"mode":        "fast",
+				},
+				Weight:      2.5,
+				TargetCount: 3,
+				MaxGames:    4,
+			},
+			{
+				Name: "chat",
+				Factors: map[string]interface{}{
+					"playerCount": 2,
+					"ratio":       float64(1),
+					"chat":        true,
+					"code":        "true",
+					"config":      map[string]interface{}{"rounds": float64(3), "labels": []interface{}{"a", "b"}},
+				},
+				MaxGames: 10,
+			},
+		},
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	for _, comma := range []rune{',', ';', '\t'} {
+		t.Run(string(comma), func(t *testing.T) {
+			want := csvTreatments()
+
+			var buf bytes.Buffer
+			if err := want.WriteCSV(&buf, comma); err != nil {
+				t.Fatalf("write csv: %v", err)
+			}
+
+			got := &Treatments{Factors: csvTreatments().Factors}
+			if err := got.ReadCSV(&buf, comma); err != nil {
+				t.Fatalf("read csv: %v", err)
+			}
+
+			if !reflect.DeepEqual(got.Factors, want.Factors) {
+				t.Errorf("factors changed")
+			}
+
+			if len(got.Treatments) != len(want.Treatments) {
+				t.Fatalf("got %d treatments, want %d", len(got.Treatments), len(want.Treatments))
+			}
+
+			for i := range want.Treatments {
+				if !reflect.DeepEqual(got.Treatments[i], want.Treatments[i]) {
+					t.Errorf("treatment %d: got %+v, want %+v", i, got.Treatments[i], want.Treatments[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCSVQuotaColumns(t *testing.T) {
+	tr := csvTreatments()
+	tr.Treatments[0].TargetCount = 0
+
+	var buf bytes.Buffer
+	if err := tr.WriteCSV(&buf, ','); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+
+	header := strings.SplitN(buf.String(), "\n", 2)[0]
+
+	want := "name,desc,playerCount,ratio,chat,code,mode,config,weight,maxGames"
+	if header != want {
+		t.Errorf("got header %s, want %s", header, want)
+	}
+}
+
+func TestReadCSVUndeclared(t *testing.T) {
+	tr := &Treatments{}
+
+	err := tr.ReadCSV(strings.NewReader("\xef\xbb\xbfname,playerCount,ratio,chat,color\na,1,0.5,true,red\n,,,,\nb,2,,false,blue\n"), ',')
+	if err != nil {
+		t.Fatalf("read csv: %v", err)
+	}
+
+	if len(tr.Treatments) != 2 {
+		t.Fatalf("got %d treatments, want 2", len(tr.Treatments))
+	}
+
+	want := map[string]interface{}{"playerCount": 1, "ratio": 0.5, "chat": true, "color": "red"}
+	if !reflect.DeepEqual(tr.Treatments[0].Factors, want) {
+		t.Errorf("got factors %v, want %v", tr.Treatments[0].Factors, want)
+	}
+
+	if _, ok := tr.Treatments[1].Factors["ratio"]; ok {
+		t.Error("empty cell set the factor")
+	}
+
+	var names []string
+	for _, f := range tr.Factors {
+		names = append(names, f.Name)
+	}
+
+	if got := strings.Join(names, ","); got != "chat,color,playerCount,ratio" {
+		t.Errorf("got declared factors %s", got)
+	}
+
+	if f := tr.factor("color"); f == nil || len(f.Values) != 2 {
+		t.Errorf("got color factor %+v, want 2 values", f)
+	}
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	typed := func() *Treatments {
+		return &Treatments{Factors: []*Factor{{Name: "playerCount", Type: TypeInteger}}}
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty", "", "empty csv"},
+		{"missing name", "playerCount\n1\n", "missing name column"},
+		{"duplicate column", "name,a,a\nx,1,2\n", "duplicate column: a"},
+		{"empty header", "name,,a\nx,1,2\n", "column 2: empty header"},
+		{"typed value", "name,playerCount\nx,1\ny,two\n", "line 3: column playerCount"},
+		{"weight", "name,weight\nx,heavy\n", "line 2: column weight"},
+		{"max games", "name,maxGames\nx,1.5\n", "line 2: column maxGames"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := typed().ReadCSV(strings.NewReader(tt.input), ',')
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
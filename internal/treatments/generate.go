@@ -0,0 +1,324 @@
+package treatments
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Designs, see Design.Kind.
+const (
+	// DesignFull crosses every value of the factors (full factorial).
+	DesignFull = "full"
+
+	// DesignFractional keeps a regular fraction of the full factorial of
+	// factors with the same number n of values: the combinations where the sum
+	// of the indexes of the values is a multiple of n, n^(k-1) treatments for k
+	// factors. With 2 values per factor, it is the half fraction of the 2^k
+	// design.
+	DesignFractional = "fractional"
+
+	// DesignLatinSquare is the Latin square of 3 factors with n values each:
+	// n² treatments, where every pair of values of 2 factors appears once. It
+	// is the fractional design of 3 factors.
+	DesignLatinSquare = "latin"
+)
+
+// maxGenerated is the maximum number of treatments generated by a design, and
+// maxCombinations the maximum number of combinations of values considered.
+const (
+	maxGenerated    = 10000
+	maxCombinations = 1000000
+)
+
+// Design describes the treatments to generate from the values of the declared
+// factors, see Generate.
+type Design struct {
+	// Kind is DesignFull, DesignFractional or DesignLatinSquare. Defaults to
+	// DesignFull.
+	Kind string `json:"kind,omitempty" yaml:"kind,omitempty"`
+
+	// Factors are the names of the factors crossed. Defaults to every factor.
+	Factors []string `json:"factors,omitempty" yaml:"factors,omitempty"`
+
+	// Name is the template of the names of the treatments (text/template),
+	// executed with the values of the factors by name, e.g.
+	// "{{.playerCount}} players". Defaults to "factor=value" pairs.
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+
+	// Exclude lists combinations of values not to generate.
+	Exclude []Constraint `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+}
+
+// Constraint excludes the treatments with all its values, by factor name.
+// Values match the name of a factor value, or its value, formatted.
+type Constraint map[string]string
+
+// Generate returns the treatments of the design, from the values of the
+// factors.
+func (t *Treatments) Generate(d *Design) ([]*Treatment, error) {
+	if d == nil {
+		d = &Design{}
+	}
+
+	factors, err := t.designFactors(d.Factors)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range d.Exclude {
+		for name := range c {
+			if t.factor(name) == nil {
+				return nil, errors.Errorf("exclude: unknown factor: %s", name)
+			}
+		}
+	}
+
+	var tmpl *template.Template
+
+	if d.Name != "" {
+		tmpl, err = template.New("name").Option("missingkey=error").Parse(d.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse name template")
+		}
+	}
+
+	levels := make([]int, len(factors))
+	total := 1
+
+	for i, f := range factors {
+		levels[i] = len(f.Values)
+		total *= levels[i]
+
+		if total > maxCombinations {
+			return nil, errors.Errorf("too many combinations, more than %d", maxCombinations)
+		}
+	}
+
+	keep := func([]int) bool { return true }
+
+	switch d.Kind {
+	case "", DesignFull:
+	case DesignLatinSquare:
+		if len(factors) != 3 {
+			return nil, errors.New("latin square requires 3 factors")
+		}
+
+		fallthrough
+	case DesignFractional:
+		if len(factors) < 2 {
+			return nil, errors.New("fractional design requires at least 2 factors")
+		}
+
+		n := levels[0]
+		for i, l := range levels {
+			if l != n {
+				return nil, errors.Errorf("%s design requires factors with the same number of values: %s has %d values, %s has %d",
+					d.Kind, factors[0].Name, n, factors[i].Name, l)
+			}
+		}
+
+		keep = func(idx []int) bool {
+			sum := 0
+			for _, i := range idx {
+				sum += i
+			}
+
+			return sum%n == 0
+		}
+	default:
+		return nil, errors.Errorf("unknown design: %s", d.Kind)
+	}
+
+	var generated []*Treatment
+
+	idx := make([]int, len(factors))
+
+	for c := 0; c < total; c++ {
+		// Indexes of the values, the last factor varying fastest.
+		rem := c
+		for i := len(factors) - 1; i >= 0; i-- {
+			idx[i] = rem % levels[i]
+			rem /= levels[i]
+		}
+
+		if !keep(idx) {
+			continue
+		}
+
+		values := make(map[string]*FactorValue, len(factors))
+		for i, f := range factors {
+			values[f.Name] = f.Values[idx[i]]
+		}
+
+		if excluded(d.Exclude, values) {
+			continue
+		}
+
+		if len(generated) == maxGenerated {
+			return nil, errors.Errorf("too many treatments, more than %d", maxGenerated)
+		}
+
+		treatment := &Treatment{Factors: make(map[string]interface{}, len(factors))}
+		for name, v := range values {
+			treatment.Factors[name] = v.Value
+		}
+
+		if tmpl != nil {
+			var b strings.Builder
+			if err := tmpl.Execute(&b, treatment.Factors); err != nil {
+				return nil, errors.Wrap(err, "execute name template")
+			}
+
+			treatment.Name = b.String()
+		} else {
+			pairs := make([]string, len(factors))
+			for i, f := range factors {
+				pairs[i] = f.Name + "=" + valueLabel(values[f.Name])
+			}
+
+			treatment.Name = strings.Join(pairs, " ")
+		}
+
+		generated = append(generated, treatment)
+	}
+
+	return generated, nil
+}
+
+// Add adds the treatments, skipping those with the same factors as an
+// existing treatment, or replaces all the treatments. It returns the number of
+// treatments added.
+func (t *Treatments) Add(treatments []*Treatment, replace bool) int {
+	if replace {
+		t.Treatments = treatments
+
+		return len(treatments)
+	}
+
+	added := 0
+
+outer:
+	for _, n := range treatments {
+		if n == nil {
+			continue
+		}
+
+		for _, e := range t.Treatments {
+			if e != nil && sameFactors(e.Factors, n.Factors) {
+				continue outer
+			}
+		}
+
+		t.Treatments = append(t.Treatments, n)
+		added++
+	}
+
+	return added
+}
+
+// designFactors returns the factors by name, every factor if names is empty,
+// without their nil values.
+func (t *Treatments) designFactors(names []string) ([]*Factor, error) {
+	if len(names) == 0 {
+		for _, f := range t.Factors {
+			if f != nil {
+				names = append(names, f.Name)
+			}
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, errors.New("no factors")
+	}
+
+	factors := make([]*Factor, 0, len(names))
+	seen := make(map[string]bool, len(names))
+
+	for _, name := range names {
+		if seen[name] {
+			return nil, errors.Errorf("duplicate factor: %s", name)
+		}
+
+		seen[name] = true
+
+		f := t.factor(name)
+		if f == nil {
+			return nil, errors.Errorf("unknown factor: %s", name)
+		}
+
+		values := make([]*FactorValue, 0, len(f.Values))
+		for _, v := range f.Values {
+			if v != nil {
+				values = append(values, v)
+			}
+		}
+
+		if len(values) == 0 {
+			return nil, errors.Errorf("factor without values: %s", name)
+		}
+
+		fv := *f
+		fv.Values = values
+		factors = append(factors, &fv)
+	}
+
+	return factors, nil
+}
+
+func (t *Treatments) factor(name string) *Factor {
+	for _, f := range t.Factors {
+		if f != nil && f.Name == name {
+			return f
+		}
+	}
+
+	return nil
+}
+
+func excluded(constraints []Constraint, values map[string]*FactorValue) bool {
+outer:
+	for _, c := range constraints {
+		if len(c) == 0 {
+			continue
+		}
+
+		for name, val := range c {
+			v, ok := values[name]
+			if !ok || (v.Name != val && fmt.Sprint(v.Value) != val) {
+				continue outer
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func valueLabel(v *FactorValue) string {
+	if v.Name != "" {
+		return v.Name
+	}
+
+	return fmt.Sprint(v.Value)
+}
+
+// sameFactors compares factor values by their formatted value, as values
+// decoded from JSON and YAML have different types.
+func sameFactors(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, va := range a {
+		vb, ok := b[k]
+		if !ok || fmt.Sprint(va) != fmt.Sprint(vb) {
+			return false
+		}
+	}
+
+	return true
+}
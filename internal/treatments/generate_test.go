@@ -0,0 +1,295 @@
+package treatments
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// designTreatments returns treatments with factors a, b, c, ... of n values
+// each, 0 to n-1.
+func designTreatments(factors, n int) *Treatments {
+	t := &Treatments{}
+
+	for i := 0; i < factors; i++ {
+		f := &Factor{Name: string(rune('a' + i))}
+		for v := 0; v < n; v++ {
+			f.Values = append(f.Values, &FactorValue{Value: v})
+		}
+
+		t.Factors = append(t.Factors, f)
+	}
+
+	return t
+}
+
+// counts returns the number of treatments by combination of values of the
+// factors.
+func counts(treatments []*Treatment, factors ...string) map[string]int {
+	c := make(map[string]int)
+
+	for _, t := range treatments {
+		vals := make([]string, len(factors))
+		for i, f := range factors {
+			vals[i] = fmt.Sprint(t.Factors[f])
+		}
+
+		c[strings.Join(vals, ",")]++
+	}
+
+	return c
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name    string
+		factors int
+		n       int
+		design  *Design
+		want    int
+		// balanced factor pairs appear in every combination the same number
+		// of times.
+		balanced [][2]string
+	}{
+		{
+			name:     "full 2x2x2",
+			factors:  3,
+			n:        2,
+			design:   &Design{},
+			want:     8,
+			balanced: [][2]string{{"a", "b"}, {"a", "c"}, {"b", "c"}},
+		},
+		{
+			name:     "full 3x3",
+			factors:  2,
+			n:        3,
+			design:   &Design{Kind: DesignFull},
+			want:     9,
+			balanced: [][2]string{{"a", "b"}},
+		},
+		{
+			name:     "full selected factors",
+			factors:  3,
+			n:        2,
+			design:   &Design{Factors: []string{"a", "c"}},
+			want:     4,
+			balanced: [][2]string{{"a", "c"}},
+		},
+		{
+			name:     "half fraction 2^4",
+			factors:  4,
+			n:        2,
+			design:   &Design{Kind: DesignFractional},
+			want:     8,
+			balanced: [][2]string{{"a", "b"}, {"a", "d"}, {"c", "d"}},
+		},
+		{
+			name:     "fractional 3^3",
+			factors:  3,
+			n:        3,
+			design:   &Design{Kind: DesignFractional},
+			want:     9,
+			balanced: [][2]string{{"a", "b"}, {"a", "c"}, {"b", "c"}},
+		},
+		{
+			name:     "latin square 4",
+			factors:  3,
+			n:        4,
+			design:   &Design{Kind: DesignLatinSquare},
+			want:     16,
+			balanced: [][2]string{{"a", "b"}, {"a", "c"}, {"b", "c"}},
+		},
+		{
+			name:    "full with exclusion",
+			factors: 2,
+			n:       3,
+			design:  &Design{Exclude: []Constraint{{"a": "0", "b": "1"}, {"a": "2"}}},
+			want:    5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := designTreatments(tt.factors, tt.n)
+
+			got, err := tr.Generate(tt.design)
+			if err != nil {
+				t.Fatalf("generate: %v", err)
+			}
+
+			if len(got) != tt.want {
+				t.Fatalf("got %d treatments, want %d", len(got), tt.want)
+			}
+
+			if c := counts(got, "a", "b", "c", "d"); len(c) != len(got) {
+				t.Errorf("got %d distinct treatments of %d", len(c), len(got))
+			}
+
+			for _, pair := range tt.balanced {
+				c := counts(got, pair[0], pair[1])
+
+				if len(c) != tt.n*tt.n {
+					t.Errorf("%s x %s: got %d combinations, want %d", pair[0], pair[1], len(c), tt.n*tt.n)
+				}
+
+				for combination, n := range c {
+					if n != len(got)/(tt.n*tt.n) {
+						t.Errorf("%s x %s: %s appears %d times, want %d", pair[0], pair[1], combination, n, len(got)/(tt.n*tt.n))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateLatinSquare(t *testing.T) {
+	const n = 5
+
+	got, err := designTreatments(3, n).Generate(&Design{Kind: DesignLatinSquare})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	// Factor a is the row, b the column and c the symbol of the square.
+	square := make([][]int, n)
+	for i := range square {
+		square[i] = make([]int, n)
+		for j := range square[i] {
+			square[i][j] = -1
+		}
+	}
+
+	for _, tr := range got {
+		row, col := tr.Factors["a"].(int), tr.Factors["b"].(int)
+		if square[row][col] != -1 {
+			t.Fatalf("cell %d,%d filled twice", row, col)
+		}
+
+		square[row][col] = tr.Factors["c"].(int)
+	}
+
+	for i := 0; i < n; i++ {
+		inRow, inCol := make(map[int]bool), make(map[int]bool)
+
+		for j := 0; j < n; j++ {
+			if square[i][j] == -1 || square[j][i] == -1 {
+				t.Fatalf("row or column %d not filled", i)
+			}
+
+			if inRow[square[i][j]] {
+				t.Errorf("row %d: symbol %d appears twice", i, square[i][j])
+			}
+
+			if inCol[square[j][i]] {
+				t.Errorf("column %d: symbol %d appears twice", i, square[j][i])
+			}
+
+			inRow[square[i][j]] = true
+			inCol[square[j][i]] = true
+		}
+	}
+}
+
+func TestGenerateNames(t *testing.T) {
+	tr := designTreatments(2, 2)
+	tr.Factors[0].Values[1].Name = "one"
+
+	got, err := tr.Generate(&Design{})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	if got[3].Name != "a=one b=1" {
+		t.Errorf("got default name %q, want %q", got[3].Name, "a=one b=1")
+	}
+
+	got, err = tr.Generate(&Design{Name: "{{.a}}-{{.b}}"})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	if got[3].Name != "1-1" {
+		t.Errorf("got template name %q, want %q", got[3].Name, "1-1")
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	uneven := designTreatments(2, 2)
+	uneven.Factors[1].Values = append(uneven.Factors[1].Values, &FactorValue{Value: 2})
+
+	tests := []struct {
+		name   string
+		t      *Treatments
+		design *Design
+		want   string
+	}{
+		{"no factors", &Treatments{}, &Design{}, "no factors"},
+		{"unknown factor", designTreatments(2, 2), &Design{Factors: []string{"z"}}, "unknown factor: z"},
+		{"duplicate factor", designTreatments(2, 2), &Design{Factors: []string{"a", "a"}}, "duplicate factor: a"},
+		{"latin square of 2 factors", designTreatments(2, 3), &Design{Kind: DesignLatinSquare}, "latin square requires 3 factors"},
+		{"fractional of 1 factor", designTreatments(1, 3), &Design{Kind: DesignFractional}, "at least 2 factors"},
+		{"fractional uneven", uneven, &Design{Kind: DesignFractional}, "same number of values"},
+		{"unknown design", designTreatments(2, 2), &Design{Kind: "random"}, "unknown design: random"},
+		{"exclude unknown factor", designTreatments(2, 2), &Design{Exclude: []Constraint{{"z": "1"}}}, "unknown factor: z"},
+		{"invalid template", designTreatments(2, 2), &Design{Name: "{{.a"}, "parse name template"},
+		{"missing template key", designTreatments(2, 2), &Design{Name: "{{.z}}"}, "execute name template"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.t.Generate(tt.design)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateNilEntries(t *testing.T) {
+	tr := designTreatments(2, 2)
+	tr.Factors = append([]*Factor{nil}, tr.Factors...)
+	tr.Factors[1].Values = append(tr.Factors[1].Values, nil)
+	tr.Treatments = []*Treatment{nil}
+
+	got, err := tr.Generate(&Design{Exclude: []Constraint{{"a": "0", "b": "0"}}})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	if len(got) != 3 {
+		t.Fatalf("got %d treatments, want 3", len(got))
+	}
+
+	if added := tr.Add(append(got, nil), false); added != 3 {
+		t.Errorf("added %d treatments, want 3", added)
+	}
+
+	if _, err := tr.Generate(&Design{Factors: []string{"a", "missing"}}); err == nil {
+		t.Error("got no error for an unknown factor")
+	}
+}
+
+func TestAdd(t *testing.T) {
+	tr := designTreatments(2, 2)
+	tr.Treatments = []*Treatment{{Name: "existing", Factors: map[string]interface{}{"a": 0, "b": 1}}}
+
+	generated, err := tr.Generate(&Design{})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	// Values decoded from JSON are float64, and compare equal to int values.
+	generated[0].Factors["a"] = float64(0)
+
+	if added := tr.Add(generated, false); added != 3 {
+		t.Errorf("added %d treatments, want 3", added)
+	}
+
+	if len(tr.Treatments) != 4 || tr.Treatments[0].Name != "existing" {
+		t.Errorf("got %d treatments, want the existing one and 3 added", len(tr.Treatments))
+	}
+
+	if added := tr.Add(generated, true); added != 4 || len(tr.Treatments) != 4 {
+		t.Errorf("replaced with %d treatments, want 4", added)
+	}
+}
@@ -5,16 +5,48 @@ type FactorValue struct {
 	Value interface{} `validate:"required" json:"value" yaml:"value"`
 }
 
+// Factor types, see Factor.Type.
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+)
+
 type Factor struct {
 	Name   string         `validate:"required,gt=0,alphanumunicode" json:"name" yaml:"name"`
 	Desc   string         `json:"desc,omitempty" yaml:"desc,omitempty"`
 	Values []*FactorValue `json:"values" yaml:"values"`
+
+	// Type, Min, Max and Enum constrain the values of the factor, in Values
+	// and in treatments. Values are not typed if Type is empty. Min and Max
+	// are the bounds of integer and number values.
+	Type string        `validate:"omitempty,oneof=string integer number boolean" json:"type,omitempty" yaml:"type,omitempty"`
+	Min  *float64      `json:"min,omitempty" yaml:"min,omitempty"`
+	Max  *float64      `json:"max,omitempty" yaml:"max,omitempty"`
+	Enum []interface{} `json:"enum,omitempty" yaml:"enum,omitempty"`
+
+	// Required factors must be set on every treatment, unless they have a
+	// Default, which applies to treatments without the factor, see
+	// SetDefaults.
+	Required bool        `json:"required,omitempty" yaml:"required,omitempty"`
+	Default  interface{} `json:"default,omitempty" yaml:"default,omitempty"`
 }
 
 type Treatment struct {
 	Name    string                 `json:"name,omitempty" yaml:"name,omitempty"`
 	Desc    string                 `json:"desc,omitempty" yaml:"desc,omitempty"`
 	Factors map[string]interface{} `validate:"required,dive,keys,gt=0,alphanumunicode,endkeys" json:"factors" yaml:"factors"`
+
+	// Weight is the relative frequency of the treatment in random
+	// assignments, 1 if not set.
+	Weight float64 `validate:"omitempty,gt=0" json:"weight,omitempty" yaml:"weight,omitempty"`
+
+	// TargetCount is the number of completed games wanted for the treatment,
+	// which random assignments fill first, and MaxGames the maximum number of
+	// completed games, both across all batches. Not set if 0.
+	TargetCount int `validate:"gte=0" json:"targetCount,omitempty" yaml:"targetCount,omitempty"`
+	MaxGames    int `validate:"gte=0" json:"maxGames,omitempty" yaml:"maxGames,omitempty"`
 }
 
 type Treatments struct {
@@ -0,0 +1,126 @@
+package treatments
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// SetDefaults sets the Default of factors on the treatments without them.
+func (t *Treatments) SetDefaults() {
+	for _, f := range t.Factors {
+		if f == nil || f.Default == nil {
+			continue
+		}
+
+		for _, treatment := range t.Treatments {
+			if treatment == nil {
+				continue
+			}
+
+			if treatment.Factors == nil {
+				treatment.Factors = make(map[string]interface{})
+			}
+
+			if _, ok := treatment.Factors[f.Name]; !ok {
+				treatment.Factors[f.Name] = f.Default
+			}
+		}
+	}
+}
+
+// checkValue returns a message if the value does not match the Type, Min, Max
+// or Enum of the factor, or an empty string.
+func (f *Factor) checkValue(val interface{}) string {
+	if msg := f.checkType(val); msg != "" {
+		return msg
+	}
+
+	if len(f.Enum) > 0 && !inValues(f.Enum, val) {
+		s := make([]string, len(f.Enum))
+		for i, v := range f.Enum {
+			s[i] = fmt.Sprint(v)
+		}
+
+		return "must be one of: " + strings.Join(s, ", ")
+	}
+
+	return ""
+}
+
+// checkType returns a message if the value does not match the Type, Min or
+// Max of the factor, or an empty string.
+func (f *Factor) checkType(val interface{}) string {
+	switch f.Type {
+	case "":
+		return ""
+	case TypeString:
+		if _, ok := val.(string); !ok {
+			return "must be a string"
+		}
+	case TypeBoolean:
+		if _, ok := val.(bool); !ok {
+			return "must be a boolean"
+		}
+	case TypeInteger, TypeNumber:
+		n, ok := number(val)
+		if !ok {
+			return "must be a number"
+		}
+
+		if f.Type == TypeInteger && n != math.Trunc(n) {
+			return "must be an integer"
+		}
+
+		if f.Min != nil && n < *f.Min {
+			return "must be at least " + formatNumber(*f.Min)
+		}
+
+		if f.Max != nil && n > *f.Max {
+			return "must be at most " + formatNumber(*f.Max)
+		}
+	}
+
+	return ""
+}
+
+// numeric returns true if the factor has integer or number values.
+func (f *Factor) numeric() bool {
+	return f.Type == TypeInteger || f.Type == TypeNumber
+}
+
+// number returns the value as a float64, for the numbers decoded from YAML
+// and JSON.
+func number(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// inValues compares values by their formatted value, as values decoded from
+// JSON and YAML have different types.
+func inValues(values []interface{}, val interface{}) bool {
+	s := fmt.Sprint(val)
+
+	for _, v := range values {
+		if fmt.Sprint(v) == s {
+			return true
+		}
+	}
+
+	return false
+}
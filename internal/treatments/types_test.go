@@ -0,0 +1,91 @@
+package treatments
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSetDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		factors []*Factor
+		set     map[string]interface{}
+		want    map[string]interface{}
+	}{
+		{
+			name:    "missing factor",
+			factors: []*Factor{{Name: "rounds", Default: 3}},
+			set:     map[string]interface{}{"playerCount": 1},
+			want:    map[string]interface{}{"playerCount": 1, "rounds": 3},
+		},
+		{
+			name:    "set factor",
+			factors: []*Factor{{Name: "rounds", Default: 3}},
+			set:     map[string]interface{}{"rounds": 5},
+			want:    map[string]interface{}{"rounds": 5},
+		},
+		{
+			name:    "without default",
+			factors: []*Factor{{Name: "rounds"}, nil},
+			set:     map[string]interface{}{"playerCount": 1},
+			want:    map[string]interface{}{"playerCount": 1},
+		},
+		{
+			name:    "without factors",
+			factors: []*Factor{{Name: "rounds", Default: false}},
+			want:    map[string]interface{}{"rounds": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &Treatments{
+				Factors:    tt.factors,
+				Treatments: []*Treatment{{Factors: tt.set}, nil},
+			}
+
+			tr.SetDefaults()
+
+			if got := tr.Treatments[0].Factors; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got factors %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckValue(t *testing.T) {
+	min, max := 1.0, 2.5
+
+	tests := []struct {
+		name   string
+		factor *Factor
+		val    interface{}
+		want   string
+	}{
+		{name: "untyped", factor: &Factor{}, val: []interface{}{1}},
+		{name: "string", factor: &Factor{Type: TypeString}, val: "a"},
+		{name: "not a string", factor: &Factor{Type: TypeString}, val: 1, want: "must be a string"},
+		{name: "boolean", factor: &Factor{Type: TypeBoolean}, val: true},
+		{name: "not a boolean", factor: &Factor{Type: TypeBoolean}, val: "true", want: "must be a boolean"},
+		{name: "integer from yaml", factor: &Factor{Type: TypeInteger}, val: 2},
+		{name: "integer from json", factor: &Factor{Type: TypeInteger}, val: 2.0},
+		{name: "not an integer", factor: &Factor{Type: TypeInteger}, val: 2.5, want: "must be an integer"},
+		{name: "large integer", factor: &Factor{Type: TypeInteger}, val: uint64(1 << 63)},
+		{name: "not a number", factor: &Factor{Type: TypeNumber}, val: "2", want: "must be a number"},
+		{name: "min", factor: &Factor{Type: TypeNumber, Min: &min}, val: 1},
+		{name: "below min", factor: &Factor{Type: TypeNumber, Min: &min}, val: 0.5, want: "must be at least 1"},
+		{name: "max", factor: &Factor{Type: TypeNumber, Max: &max}, val: 2.5},
+		{name: "above max", factor: &Factor{Type: TypeNumber, Max: &max}, val: int64(3), want: "must be at most 2.5"},
+		{name: "enum", factor: &Factor{Enum: []interface{}{1, "a"}}, val: 1.0},
+		{name: "not in enum", factor: &Factor{Enum: []interface{}{1, "a"}}, val: "b", want: "must be one of: 1, a"},
+		{name: "type before enum", factor: &Factor{Type: TypeString, Enum: []interface{}{"a"}}, val: 1, want: "must be a string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.factor.checkValue(tt.val); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
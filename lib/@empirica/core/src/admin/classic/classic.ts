@@ -3,7 +3,7 @@ import { z } from "zod";
 import { Attribute } from "../../shared/attributes";
 import { debug, error, trace, warn } from "../../utils/console";
 import { deepEqual } from "../../utils/object";
-import { pickRandom, selectRandom } from "../../utils/random";
+import { pickRandom, pickWeighted, selectRandom } from "../../utils/random";
 import { EventContext, ListenersCollector, TajribaEvent } from "../events";
 import { Participant } from "../participants";
 import { Step, Transition } from "../transitions";
@@ -19,7 +19,7 @@ import {
   Stage,
   evt,
 } from "./models";
-import { batchConfigSchema, factorsSchema } from "./schemas";
+import { batchConfigSchema, factorsSchema, treatmentSchema } from "./schemas";
 
 // const isBatch = z.instanceof(Batch).parse;
 const isGame = z.instanceof(Game).parse;
@@ -27,6 +27,56 @@ const isRound = z.instanceof(Round).parse;
 const isStage = z.instanceof(Stage).parse;
 const isString = z.string().parse;
 
+type Treatment = z.infer<typeof treatmentSchema>;
+
+// treatmentGames counts the completed games of each of the treatments, in all
+// batches. Games not started, running, failed or terminated do not count
+// toward the quotas.
+function treatmentGames(games: Iterable<Game>, treatments: Treatment[]) {
+  const counts = new Map<Treatment, number>();
+  for (const game of games) {
+    const factors = game.get("treatment");
+    if (!factors || game.get("status") !== "ended") {
+      continue;
+    }
+
+    const treatment = treatments.find(
+      (t) =>
+        (t.name || "") === (game.get("treatmentName") || "") &&
+        deepEqual(t.factors, factors)
+    );
+    if (treatment) {
+      counts.set(treatment, (counts.get(treatment) || 0) + 1);
+    }
+  }
+
+  return counts;
+}
+
+// pickTreatment picks a treatment by weight among the treatments that have not
+// reached their maxGames, and first among those below their targetCount. It
+// returns undefined if all treatments reached their maxGames.
+function pickTreatment(
+  treatments: Treatment[],
+  counts: Map<Treatment, number>
+) {
+  const available = treatments.filter(
+    (t) => !t.maxGames || (counts.get(t) || 0) < t.maxGames
+  );
+  if (available.length === 0) {
+    return;
+  }
+
+  const belowTarget = available.filter(
+    (t) => t.targetCount && (counts.get(t) || 0) < t.targetCount
+  );
+
+  return pickWeighted(
+    belowTarget.length > 0 ? belowTarget : available,
+    (t) => t.weight ?? 1
+  );
+}
+
 export type ClassicConfig = {
   /**
    * Disables automatic assignment of players on the connection of a new player.
@@ -379,7 +429,7 @@ export function Classic({
       }
     });
 
-    _.on("batch", (_, { batch }: { batch: Batch }) => {
+    _.on("batch", (ctx, { batch }: { batch: Batch }) => {
       if (disableGameCreation || batch.get("initialized")) {
         return;
       }
@@ -389,9 +439,21 @@ export function Classic({
       const config = batchConfigSchema.parse(batch.get("config"));
 
       switch (config.kind) {
-        case "simple":
+        case "simple": {
+          // Quotas count the completed games of previous batches, and the
+          // games created for this batch.
+          const counts = treatmentGames(
+            ctx.scopesByKind<Game>("game").values(),
+            config.config.treatments
+          );
           for (let i = 0; i < config.config.count; i++) {
-            const treatment = pickRandom(config.config.treatments);
+            const treatment = pickTreatment(config.config.treatments, counts);
+            if (!treatment) {
+              warn("callbacks: simple batch treatments reached maxGames");
+              break;
+            }
+
+            counts.set(treatment, (counts.get(treatment) || 0) + 1);
             batch.addGame([
               {
                 key: "treatment",
@@ -407,9 +469,32 @@ export function Classic({
           }
 
           break;
-        case "complete":
+        }
+        case "complete": {
+          const counts = treatmentGames(
+            ctx.scopesByKind<Game>("game").values(),
+            config.config.treatments.map((t) => t.treatment)
+          );
           for (const t of config.config.treatments) {
-            for (let i = 0; i < t.count; i++) {
+            let count = t.count;
+            const max = t.treatment.maxGames;
+            if (max) {
+              count = Math.max(
+                0,
+                Math.min(count, max - (counts.get(t.treatment) || 0))
+              );
+              counts.set(t.treatment, (counts.get(t.treatment) || 0) + count);
+            }
+
+            if (count < t.count) {
+              warn(
+                `callbacks: complete batch treatment reached maxGames: ${
+                  t.treatment.name || JSON.stringify(t.treatment.factors)
+                }`
+              );
+            }
+
+            for (let i = 0; i < count; i++) {
               batch.addGame([
                 {
                   key: "treatment",
@@ -426,6 +511,7 @@ export function Classic({
           }
 
           break;
+        }
         default:
           warn("callbacks: batch created without a config");
 
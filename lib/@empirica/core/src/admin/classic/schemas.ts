@@ -4,6 +4,9 @@ export const factorsSchema = z.record(z.string().min(1), z.any());
 export const treatmentSchema = z.object({
   factors: factorsSchema,
   name: z.string().optional(),
+  weight: z.number().positive().optional(),
+  targetCount: z.number().int().nonnegative().optional(),
+  maxGames: z.number().int().nonnegative().optional(),
 });
 
 export const batchConfigSchema = z.discriminatedUnion("kind", [
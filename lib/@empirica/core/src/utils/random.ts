@@ -14,3 +14,16 @@ export function shuffle(a: Array<any>) {
 export function selectRandom(arr: Array<any>, num: number) {
   return shuffle(arr.slice()).slice(0, num);
 }
+
+export function pickWeighted<T>(items: T[], weight: (item: T) => number): T {
+  const total = items.reduce((sum, item) => sum + weight(item), 0);
+  let random = Math.random() * total;
+  for (const item of items) {
+    random -= weight(item);
+    if (random < 0) {
+      return item;
+    }
+  }
+
+  return items[items.length - 1] as T;
+}
@@ -1,6 +1,18 @@
 import { writable } from "svelte/store";
+import { DEFAULT_TOKEN_KEY } from "../constants.js";
 
 const admin = writable(null);
 
 export const currentAdmin = { subscribe: admin.subscribe };
 export const setCurrentAdmin = admin.set;
+
+// Headers authenticating requests to the server endpoints (treatments and
+// lobbies) with the session token of the admin.
+export function authHeaders(headers = {}) {
+  const token = window.localStorage.getItem(DEFAULT_TOKEN_KEY);
+  if (!token) {
+    return headers;
+  }
+
+  return { ...headers, Authorization: `Bearer ${token}` };
+}
@@ -22,17 +22,28 @@ const completeSchema = yup.object().shape({
   treatments: yup
     .array()
     .of(
-      yup.object().shape({
-        count: yup.number().required().positive().integer(),
-        treatment: yup
-          .object()
-          .required()
-          .shape({
-            factors: yup.object().required().shape({
-              playerCount: yup.number().required().positive().integer(),
+      yup
+        .object()
+        .shape({
+          count: yup.number().required().positive().integer(),
+          treatment: yup
+            .object()
+            .required()
+            .shape({
+              factors: yup.object().required().shape({
+                playerCount: yup.number().required().positive().integer(),
+              }),
             }),
-          }),
-      })
+        })
+        .test(
+          "maxGames",
+          ({ value }) =>
+            `${value.treatment.name || "Treatment"}: ${value.count} games, ` +
+            `more than its maximum of ${value.treatment.maxGames}`,
+          (value) =>
+            !value?.treatment?.maxGames ||
+            value.count <= value.treatment.maxGames
+        )
     )
     .required()
     .min(1),
@@ -0,0 +1,16 @@
+// Error of a request to the server endpoints (treatments and lobbies), from
+// the errors of the response: {"errors": [{"field", "message"}]}.
+export async function responseError(res) {
+  let errors = [];
+  try {
+    errors = (await res.json()).errors || [];
+  } catch (error) {
+    console.info("parse error response", error);
+  }
+
+  const msg = errors
+    .map((e) => (e.field ? `${e.field} ${e.message}` : e.message))
+    .join("\n");
+
+  return new Error(msg || `${res.status} ${res.statusText}`);
+}
@@ -1,8 +1,15 @@
 import { ORIGIN } from "../constants.js";
+import { authHeaders } from "./auth.js";
+import { responseError } from "./errors.js";
 import { durationString } from "./time.js";
 
 export async function getLobbies() {
-  return (await fetch(ORIGIN + "/lobbies")).json();
+  const res = await fetch(ORIGIN + "/lobbies", { headers: authHeaders() });
+  if (!res.ok) {
+    throw await responseError(res);
+  }
+
+  return res.json();
 }
 
 export function formatLobby(lobby) {
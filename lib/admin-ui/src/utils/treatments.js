@@ -1,7 +1,14 @@
 import { ORIGIN } from "../constants.js";
+import { authHeaders } from "./auth.js";
+import { responseError } from "./errors.js";
 
 export async function getTreatments() {
-  return (await fetch(ORIGIN + "/treatments")).json();
+  const res = await fetch(ORIGIN + "/treatments", { headers: authHeaders() });
+  if (!res.ok) {
+    throw await responseError(res);
+  }
+
+  return res.json();
 }
 
 export function formatFactorsToString(factors, sep = " | ") {
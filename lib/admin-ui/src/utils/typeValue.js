@@ -6,3 +6,27 @@ export function castValue(value) {
     return value;
   }
 }
+
+// Cast a value entered in the admin to the type of its factor (string,
+// integer, number or boolean). Untyped values are parsed as JSON.
+export function castTypedValue(value, type) {
+  switch (type) {
+    case "string":
+      return String(value);
+    case "integer":
+    case "number": {
+      const n = Number(value);
+      return value === "" || isNaN(n) ? value : n;
+    }
+    case "boolean":
+      if (value === true || value === "true") {
+        return true;
+      }
+      if (value === false || value === "false") {
+        return false;
+      }
+      return value;
+    default:
+      return castValue(value);
+  }
+}